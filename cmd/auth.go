@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Roles a user can have, each able to do everything the ones before it can
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// roleLevels ranks the roles so they can be compared
+var roleLevels = map[string]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+// SessionCookie is the name of the cookie holding the signed-in user
+const SessionCookie = "mfw-session"
+
+// SessionMaxAge is how long (in seconds) someone stays signed in
+const SessionMaxAge = 60 * 60 * 24 * 30
+
+// dummyPasswordHash is checked against when the user doesn't exist, so
+// unknown users take as long to reject as wrong passwords
+const dummyPasswordHash = "$2a$10$LeVISheRiGTsUVBmX6qGwu2fx54TEjfI.kbKwAnY5b4RvA2Z50vbK"
+
+// User is someone signed in to the website
+type User struct {
+	Name string
+	Role string
+}
+
+// userContextKey is where the signed-in user is kept in a request's context
+type userContextKey struct{}
+
+// HasRole returns true if the user can do what the role can
+// A nil user means sign in isn't needed, so everything is allowed
+func (u *User) HasRole(role string) bool {
+	return u == nil || roleLevels[u.Role] >= roleLevels[role]
+}
+
+// HashPassword returns the bcrypt hash of a password, for the users in the settings
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// AuthEnabled returns true if users need to sign in
+func (s *Server) AuthEnabled() bool {
+	return len(s.Config.Users) > 0
+}
+
+// Authenticate returns the user if the password is correct
+func (s *Server) Authenticate(name string, password string) (*User, bool) {
+	config, found := s.Config.Users[name]
+	hash := config.PasswordHash
+	if !found {
+		hash = dummyPasswordHash
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil || !found {
+		return nil, false
+	}
+	return &User{Name: name, Role: config.Role}, true
+}
+
+// getUser returns the user signed in with the session cookie or HTTP basic authentication
+// The user is checked against the settings each time, so removed users (and those whose
+// password has changed) are signed out
+func (s *Server) getUser(r *http.Request) *User {
+	if name, password, ok := r.BasicAuth(); ok {
+		if user, ok := s.Authenticate(name, password); ok {
+			return user
+		}
+		return nil
+	}
+	session, err := s.CookieHandler.GetCookie(r, SessionCookie)
+	if err != nil {
+		return nil
+	}
+	name, fingerprint, _ := strings.Cut(session, "|")
+	config, found := s.Config.Users[name]
+	if !found || subtle.ConstantTimeCompare([]byte(fingerprint), []byte(getPasswordFingerprint(config.PasswordHash))) != 1 {
+		return nil
+	}
+	return &User{Name: name, Role: config.Role}
+}
+
+// getSession returns the session cookie value for a user, which is their name and a fingerprint of their password
+// Changing the password changes the fingerprint, which signs out everyone signed in with the old one
+func (s *Server) getSession(user *User) string {
+	return user.Name + "|" + getPasswordFingerprint(s.Config.Users[user.Name].PasswordHash)
+}
+
+// getPasswordFingerprint returns part of the SHA-256 of a password hash, which identifies it without revealing it
+func getPasswordFingerprint(passwordHash string) string {
+	sum := sha256.Sum256([]byte(passwordHash))
+	return hex.EncodeToString(sum[:8])
+}
+
+// currentUser returns the user a request was made by, or nil if sign in isn't needed
+func currentUser(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey{}).(*User)
+	return user
+}
+
+// requireRole only lets the request through if sign in isn't needed or the user has the role
+// Everyone using the public catalog is a viewer
+// People are sent to the sign in page, but feeds and the API are asked for HTTP basic
+// authentication instead as feed readers and scripts can't use the page
+func (s *Server) requireRole(role string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.ReadOnly {
+			handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, publicViewer)))
+			return
+		}
+		if !s.AuthEnabled() {
+			handler(w, r)
+			return
+		}
+
+		user := s.getUser(r)
+		isApi := strings.HasPrefix(r.URL.Path, "/api/")
+		isFeed := strings.HasPrefix(r.URL.Path, "/feeds/") || strings.HasPrefix(r.URL.Path, "/opds/")
+		if user == nil {
+			switch {
+			case isApi || isFeed:
+				w.Header().Set("WWW-Authenticate", `Basic realm="MFW Books DB", charset="UTF-8"`)
+				if isApi {
+					writeApiError(w, http.StatusUnauthorized, "sign in is required")
+				} else {
+					http.Error(w, "Sign in is required", http.StatusUnauthorized)
+				}
+			case r.Method == http.MethodGet:
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+			default:
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			}
+			return
+		}
+		if !user.HasRole(role) {
+			if isApi {
+				writeApiError(w, http.StatusForbidden, "the %s role is required", role)
+			} else {
+				http.Error(w, "You need to be signed in as "+role+" or above to do that", http.StatusForbidden)
+			}
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+	}
+}
+
+// getSafeRedirect returns the address to go to after signing in, which must be on this site
+func getSafeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// AuthorVariant is one of the names an author appears as, with how many books use it
+type AuthorVariant struct {
+	Name  string
+	Sort  string
+	Books int
+}
+
+// AuthorSuggestion is a group of names that are probably the same author,
+// with the name and author sort suggested for them all
+type AuthorSuggestion struct {
+	Variants []AuthorVariant
+	Name     string
+	Sort     string
+}
+
+// authorMatch is an author's name as it is compared when looking for duplicates
+type authorMatch struct {
+	surname string
+	given   []string
+}
+
+// GetAuthorVariants returns each name used for an author in the books, with its author sort
+// The author sort is the one the books use, so any corrections are kept
+func GetAuthorVariants(books []Book) []AuthorVariant {
+	variants := []AuthorVariant{}
+	positions := map[string]int{}
+	for _, book := range books {
+		for i, name := range book.Authors {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if position, found := positions[name]; found {
+				variants[position].Books++
+				continue
+			}
+			sort := KnownAuthors.GetAuthorSort(name)
+			if len(book.AuthorSort) == len(book.Authors) && book.AuthorSort[i] != "" {
+				sort = book.AuthorSort[i]
+			}
+			positions[name] = len(variants)
+			variants = append(variants, AuthorVariant{Name: name, Sort: sort, Books: 1})
+		}
+	}
+	return variants
+}
+
+// SuggestAuthorMerges returns groups of names in the books that are probably the same author
+// Names match if their surnames are the same (or only a letter apart) and their forenames agree,
+// with initials matching names that start with them (eg "J. R. R. Tolkien" and "John Ronald Reuel Tolkien")
+func SuggestAuthorMerges(books []Book) []AuthorSuggestion {
+	// The most used names go first, so they start the groups
+	variants := GetAuthorVariants(books)
+	slices.SortStableFunc(variants, func(a, b AuthorVariant) int {
+		return cmp.Or(cmp.Compare(b.Books, a.Books), cmp.Compare(len(b.Name), len(a.Name)), CompareText(a.Name, b.Name))
+	})
+
+	// A name only joins a group if it matches every name already in it,
+	// so that (for example) "J Smith" doesn't bring "John Smith" and "Jane Smith" together
+	groups := [][]AuthorVariant{}
+	matches := [][]authorMatch{}
+	for _, variant := range variants {
+		match := getAuthorMatch(variant.Name)
+		joined := false
+		for i := range groups {
+			if !slices.ContainsFunc(matches[i], func(other authorMatch) bool { return !match.isLikely(other) }) {
+				groups[i] = append(groups[i], variant)
+				matches[i] = append(matches[i], match)
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			groups = append(groups, []AuthorVariant{variant})
+			matches = append(matches, []authorMatch{match})
+		}
+	}
+
+	// Suggest the most used name for each group
+	suggestions := []AuthorSuggestion{}
+	for _, group := range groups {
+		if len(group) > 1 {
+			suggestions = append(suggestions, AuthorSuggestion{Variants: group, Name: group[0].Name, Sort: group[0].Sort})
+		}
+	}
+	slices.SortStableFunc(suggestions, func(a, b AuthorSuggestion) int { return CompareText(a.Sort, b.Sort) })
+	return suggestions
+}
+
+// MergeAuthorsInBooks changes the books by any of the authority's names to use its name and author sort
+// It returns how many books were changed
+func MergeAuthorsInBooks(books []Book, authority AuthorAuthority) int {
+	keys := map[string]bool{}
+	for _, name := range authority.Names() {
+		keys[getAuthorKey(name)] = true
+	}
+
+	changed := 0
+	for i := range books {
+		book := &books[i]
+		if !slices.ContainsFunc(book.Authors, func(name string) bool { return keys[getAuthorKey(name)] }) {
+			continue
+		}
+
+		// Swap in the authority, keeping the other authors (and their sorts) as they are
+		// If the authority now appears twice only the first is kept
+		// Books whose sorts don't match their authors have the sorts worked out again
+		sortsMatch := len(book.AuthorSort) == len(book.Authors)
+		authors, sorts, seen := []string{}, []string{}, false
+		for j, name := range book.Authors {
+			var sort string
+			if sortsMatch {
+				sort = book.AuthorSort[j]
+			} else {
+				sort = KnownAuthors.GetAuthorSort(name)
+			}
+			if keys[getAuthorKey(name)] {
+				if seen {
+					continue
+				}
+				name, sort, seen = authority.Name, authority.Sort, true
+			}
+			authors = append(authors, name)
+			sorts = append(sorts, sort)
+		}
+		if !slices.Equal(authors, book.Authors) || !slices.Equal(sorts, book.AuthorSort) {
+			book.Authors, book.AuthorSort = authors, sorts
+			changed++
+		}
+	}
+	return changed
+}
+
+// getAuthorMatch returns an author's name as it is compared when looking for duplicates
+// Organisations and names written family name first are compared as a whole
+func getAuthorMatch(name string) authorMatch {
+	parsed := ParseAuthorName(name)
+	if parsed.Organisation || parsed.FamilyFirst {
+		return authorMatch{surname: getMatchLetters(parsed.Written)}
+	}
+	match := authorMatch{surname: getMatchLetters(parsed.Surname), given: []string{}}
+	for _, word := range strings.Fields(parsed.Given) {
+		// Initials are run together (eg "JRR"), so are split up again
+		if len([]rune(word)) <= 3 && strings.ToUpper(word) == word {
+			for _, r := range word {
+				match.given = append(match.given, getMatchLetters(string(r)))
+			}
+		} else {
+			match.given = append(match.given, getMatchLetters(word))
+		}
+	}
+	return match
+}
+
+// isLikely returns true if two names are probably the same author
+func (m authorMatch) isLikely(other authorMatch) bool {
+	if m.surname != other.surname && (len(m.surname) < 5 || len(other.surname) < 5 || getEditDistance(m.surname, other.surname) > 1) {
+		return false
+	}
+	if len(m.given) == 0 || len(other.given) == 0 {
+		return len(m.given) == len(other.given)
+	}
+	for i := range min(len(m.given), len(other.given)) {
+		a, b := m.given[i], other.given[i]
+		if a != b && !isInitialOf(a, b) && !isInitialOf(b, a) {
+			return false
+		}
+	}
+	return true
+}
+
+// isInitialOf returns true if the first text is the initial of the second (eg "j" and "john")
+func isInitialOf(initial string, name string) bool {
+	return len([]rune(initial)) == 1 && strings.HasPrefix(name, initial)
+}
+
+// getMatchLetters returns just the letters and digits of some text, as they are collated
+func getMatchLetters(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, BookCollation.Key(text))
+}
+
+// getEditDistance returns how many letters need adding, removing, or changing to turn one text into the other
+func getEditDistance(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	previous := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := range x {
+		current := make([]int, len(y)+1)
+		current[0] = i + 1
+		for j := range y {
+			cost := 1
+			if x[i] == y[j] {
+				cost = 0
+			}
+			current[j+1] = min(previous[j+1]+1, current[j]+1, previous[j]+cost)
+		}
+		previous = current
+	}
+	return previous[len(y)]
+}
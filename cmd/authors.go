@@ -0,0 +1,456 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// AuthorsFilename is where author sort corrections are kept, next to the books file
+const AuthorsFilename = "mfw-authors.json"
+
+// AuthorName is an author's name split into the parts used for the author sort
+type AuthorName struct {
+	// Given is the forenames and initials (eg "Ursula K"), and Surname includes any
+	// prefixes filed with it (eg "Le Guin")
+	Given   string
+	Surname string
+
+	// Particle is filed after the forenames (eg "Goethe, Johann Wolfgang von")
+	Particle string
+
+	// Suffix is filed last (eg "King, Martin Luther, Jr")
+	Suffix string
+
+	// Written is the whole name, tidied, which is the sort for organisations
+	// and names written family name first (eg "BBC Books" or "村上 春樹")
+	Written      string
+	Organisation bool
+	FamilyFirst  bool
+}
+
+// authorParticles are filed after the forenames rather than with the surname (eg "von")
+var authorParticles = []string{"von", "zu", "vom", "zum"}
+
+// authorPrefixes are filed with the surname (eg "Le Guin", "du Maurier", "de Camp", and "van Vogt")
+var authorPrefixes = []string{
+	"le", "la", "les", "du", "des", "di", "del", "della", "dei", "lo", "st", "st.", "saint", "al", "el", "bin", "ibn", "abu", "mac",
+	"van", "de", "der", "den", "ten", "ter", "da", "das", "dos", "do",
+}
+
+// authorSuffixes are generational and honorific suffixes, filed last
+var authorSuffixes = []string{"jr", "jr.", "jnr", "sr", "sr.", "snr", "ii", "iii", "iv", "phd", "md", "obe", "mbe", "cbe", "kbe"}
+
+// familyFirstSurnames are common Chinese, Korean, and Vietnamese family names, as they are romanised
+// A name starting with one is only taken as family name first if the rest of it is romanised too (see isRomanisedGiven),
+// so that (for example) "Lin Carter" and "Kim Stanley Robinson" are still filed by their last name
+var familyFirstSurnames = []string{
+	// Chinese (pinyin, then older spellings)
+	"wang", "li", "zhang", "liu", "chen", "yang", "huang", "zhao", "wu", "zhou", "xu", "sun", "ma", "zhu", "hu",
+	"guo", "he", "lin", "gao", "luo", "zheng", "liang", "xie", "song", "tang", "feng", "deng", "cao", "peng",
+	"zeng", "xiao", "tian", "dong", "pan", "yuan", "cai", "jiang", "yu", "du", "ye", "cheng", "wei", "su", "lu",
+	"ding", "ren", "shen", "yao", "mao", "jin", "qian", "kong", "bai", "lao", "mo", "yan", "fan", "shi", "hou",
+	"cui", "tan", "lei", "long", "mei", "qiu", "chan", "wong", "cheung", "leung", "lam", "ng", "chow", "tsai", "hsu",
+	// Korean
+	"kim", "lee", "park", "choi", "jung", "jeong", "kang", "cho", "yoon", "jang", "lim", "shin", "han", "oh",
+	"seo", "kwon", "hwang", "ahn", "hong", "yoo", "ko", "moon", "baek", "nam", "ha",
+	// Vietnamese
+	"nguyen", "tran", "le", "pham", "hoang", "huynh", "phan", "vu", "vo", "dang", "bui", "do", "ho", "ngo", "duong", "ly",
+}
+
+// romanisedGivenPattern matches a given name made up of romanised Chinese, Korean, or Vietnamese syllables
+// (eg "Zedong", "Jong-un", or "Xiaoping"), which most European names aren't (eg "Carter" or "Stanley")
+var romanisedGivenPattern = regexp.MustCompile(`(?i)^(?:(?:ngh|ng|nh|th|tr|ph|kh|gi|qu|zh|ch|sh|ts|tz|hs|[bcdfghjklmnpqrstvwxyz])?[aeiouy]{1,3}(?:ng|nh|[mnptkcl])?)+(?:-(?:(?:ngh|ng|nh|th|tr|ph|kh|gi|qu|zh|ch|sh|ts|tz|hs|[bcdfghjklmnpqrstvwxyz])?[aeiouy]{1,3}(?:ng|nh|[mnptkcl])?)+)*$`)
+
+// organisationWords show that an author is an organisation, so their name is kept as it is
+var organisationWords = []string{
+	"books", "press", "publishing", "publishers", "publications", "ltd", "limited", "inc", "llc", "plc",
+	"company", "corporation", "society", "association", "council", "university", "institute", "museum",
+	"group", "team", "staff", "editors", "magazine", "foundation", "trust", "department", "ministry",
+	"library", "club", "committee", "agency", "bbc", "organisation", "organization", "studio", "studios",
+}
+
+// ParseAuthorName splits an author's name into the parts used for the author sort
+// Names already written as 'Surname, Forenames' are understood too
+func ParseAuthorName(name string) AuthorName {
+	parsed := AuthorName{Written: strings.Join(strings.Fields(name), " ")}
+	if parsed.Written == "" {
+		return parsed
+	}
+	words := strings.Fields(parsed.Written)
+	for _, word := range words {
+		if slices.Contains(organisationWords, strings.ToLower(strings.Trim(word, ".,()"))) {
+			parsed.Organisation = true
+			return parsed
+		}
+	}
+
+	// Names in Chinese, Japanese, or Korean script are written family name first, as are romanised ones
+	// that start with a family name and have a romanised given name (eg "Mao Zedong" or "Kim Jong-un")
+	// Any these miss (or wrongly catch) need their author sort correcting once (see AuthorStore)
+	if strings.ContainsFunc(parsed.Written, isFamilyFirstScript) || isRomanisedFamilyFirst(words) {
+		parsed.FamilyFirst = true
+		return parsed
+	}
+
+	// Names already in sort order (eg "Tolkien, J. R. R." or "King, Martin Luther, Jr.")
+	parts := strings.Split(parsed.Written, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if len(parts) > 1 && isAuthorSuffix(parts[len(parts)-1]) {
+		parsed.Suffix = formatAuthorSuffix(parts[len(parts)-1])
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 1 {
+		parsed.Surname = parts[0]
+		parsed.Given = formatGivenNames(strings.Fields(strings.Join(parts[1:], " ")))
+		return parsed
+	}
+	words = strings.Fields(parts[0])
+	if parsed.Suffix == "" && len(words) > 2 && isAuthorSuffix(words[len(words)-1]) {
+		parsed.Suffix = formatAuthorSuffix(words[len(words)-1])
+		words = words[:len(words)-1]
+	}
+
+	// A single name (eg "Plato")
+	if len(words) == 1 {
+		parsed.Surname = words[0]
+		return parsed
+	}
+
+	// The surname is the last word, plus any prefixes and particles before it
+	// Spanish and Portuguese compound surnames are joined by 'y' or 'e' (eg "Ortega y Gasset")
+	last := len(words) - 1
+	if last >= 3 && (words[last-1] == "y" || words[last-1] == "e") {
+		last -= 2
+	}
+	first := last
+	for first > 1 && isAuthorParticleOrPrefix(words[first-1]) {
+		first--
+	}
+	run := words[first:last]
+	if slices.ContainsFunc(run, func(word string) bool { return slices.Contains(authorParticles, strings.ToLower(word)) }) {
+		parsed.Particle = strings.Join(run, " ")
+		parsed.Surname = strings.Join(words[last:], " ")
+	} else {
+		parsed.Surname = strings.Join(words[first:], " ")
+	}
+	parsed.Given = formatGivenNames(words[:first])
+	return parsed
+}
+
+// Sort returns the author sort for the name (eg "Le Guin, Ursula K" or "Goethe, Johann Wolfgang von")
+func (n AuthorName) Sort() string {
+	if n.Organisation || n.FamilyFirst {
+		return n.Written
+	}
+	sort := n.Surname
+	if forenames := strings.TrimSpace(n.Given + " " + n.Particle); forenames != "" {
+		sort += ", " + forenames
+	}
+	if n.Suffix != "" {
+		sort += ", " + n.Suffix
+	}
+	return sort
+}
+
+// formatGivenNames tidies forenames and initials, with initials capitalised and
+// run together without their periods (eg "J. R. R." becomes "JRR")
+func formatGivenNames(words []string) string {
+	segments := []string{}
+	for _, word := range words {
+		if strings.Contains(word, ".") {
+			word = strings.ToUpper(strings.ReplaceAll(word, ".", ""))
+		}
+		if len(word) == 1 {
+			word = strings.ToUpper(word)
+		}
+		if word != "" {
+			segments = append(segments, word)
+		}
+	}
+	given, last := "", ""
+	for _, segment := range segments {
+		if given != "" && (len(last) != 1 || len(segment) != 1) {
+			given += " "
+		}
+		given += segment
+		last = segment
+	}
+	return given
+}
+
+// isAuthorParticleOrPrefix returns true if a word can come between the forenames and surname
+func isAuthorParticleOrPrefix(word string) bool {
+	word = strings.ToLower(word)
+	return slices.Contains(authorParticles, word) || slices.Contains(authorPrefixes, word) ||
+		strings.HasPrefix(word, "d'") || strings.HasPrefix(word, "o'")
+}
+
+// isFamilyFirstScript returns true for letters of the scripts whose names are written family name first
+func isFamilyFirstScript(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// isRomanisedFamilyFirst returns true if a name's words look like a romanised name written family name first
+// (eg "Deng Xiaoping" or "Nguyen Van Thieu"), with nothing in it that shows it is in another order
+func isRomanisedFamilyFirst(words []string) bool {
+	if len(words) < 2 || len(words) > 3 || !slices.Contains(familyFirstSurnames, strings.ToLower(words[0])) {
+		return false
+	}
+	for _, word := range words[1:] {
+		if !romanisedGivenPattern.MatchString(strings.ReplaceAll(word, "'", "")) {
+			return false
+		}
+	}
+	return true
+}
+
+// isAuthorSuffix returns true for suffixes such as "Jr." and "III"
+func isAuthorSuffix(word string) bool {
+	return slices.Contains(authorSuffixes, strings.ToLower(strings.TrimSpace(word)))
+}
+
+// formatAuthorSuffix returns a suffix as it is filed (eg "jr." becomes "Jr", and "iii" becomes "III")
+func formatAuthorSuffix(suffix string) string {
+	suffix = strings.TrimSuffix(strings.TrimSpace(suffix), ".")
+	if parseRomanNumeral(suffix) > 0 {
+		return strings.ToUpper(suffix)
+	}
+	runes := []rune(suffix)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+// AuthorStore remembers the author sorts chosen for author names,
+// so corrections made on the edit page are used for future books by the same author
+// It also keeps the authorities, which are the names that were merged into one (see Merge)
+type AuthorStore struct {
+	filename string
+	lock     sync.Mutex
+	authors  authorsFile
+}
+
+// authorsFile is the content of the authors file
+type authorsFile struct {
+	// Overrides maps author names (in lower case) to their author sort
+	Overrides map[string]string `json:"overrides"`
+
+	// Authorities are the authors known by more than one name
+	Authorities []AuthorAuthority `json:"authorities"`
+}
+
+// AuthorAuthority is an author known by more than one name (eg "J.R.R. Tolkien" and "John Ronald Reuel Tolkien")
+// Books by any of the aliases are given the name and author sort instead
+type AuthorAuthority struct {
+	Name    string   `json:"name"`
+	Sort    string   `json:"sort"`
+	Aliases []string `json:"aliases"`
+}
+
+// Names returns the authority's name followed by its aliases
+func (a AuthorAuthority) Names() []string {
+	return append([]string{a.Name}, a.Aliases...)
+}
+
+// KnownAuthors is the author store in use, which only remembers corrections
+// in memory unless it has been loaded from a file (see NewAuthorStore)
+var KnownAuthors = &AuthorStore{authors: newAuthorsFile()}
+
+// NewAuthorStore loads the author sort corrections kept in a file, if it exists
+func NewAuthorStore(filename string) (*AuthorStore, error) {
+	store := &AuthorStore{filename: filename, authors: newAuthorsFile()}
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.authors); err != nil {
+		return nil, fmt.Errorf("error reading authors file %s: %w", filename, err)
+	}
+	if store.authors.Overrides == nil {
+		store.authors.Overrides = map[string]string{}
+	}
+	if store.authors.Authorities == nil {
+		store.authors.Authorities = []AuthorAuthority{}
+	}
+	return store, nil
+}
+
+// newAuthorsFile returns an authors file with nothing in it
+func newAuthorsFile() authorsFile {
+	return authorsFile{Overrides: map[string]string{}, Authorities: []AuthorAuthority{}}
+}
+
+// GetAuthorName returns the name an author is known by, which is their authority's name if they have one
+func (a *AuthorStore) GetAuthorName(name string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if i := a.findAuthority(name); i >= 0 {
+		return a.authors.Authorities[i].Name
+	}
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// GetAuthorSort returns the author sort for an author's name, using their authority
+// or any correction made before
+func (a *AuthorStore) GetAuthorSort(name string) string {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if i := a.findAuthority(name); i >= 0 {
+		return a.authors.Authorities[i].Sort
+	}
+	if sort, ok := a.authors.Overrides[getAuthorKey(name)]; ok {
+		return sort
+	}
+	return ParseAuthorName(name).Sort()
+}
+
+// Authorities returns a copy of the authors known by more than one name, ordered by author sort
+func (a *AuthorStore) Authorities() []AuthorAuthority {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	authorities := []AuthorAuthority{}
+	for _, authority := range a.authors.Authorities {
+		authority.Aliases = slices.Clone(authority.Aliases)
+		authorities = append(authorities, authority)
+	}
+	slices.SortStableFunc(authorities, func(x, y AuthorAuthority) int { return CompareText(x.Sort, y.Sort) })
+	return authorities
+}
+
+// Learn remembers the author sorts given for a book's authors (matched by position)
+// where they differ from what would be worked out, and forgets any that no longer do
+// Authors with an authority have the authority's sort changed instead
+func (a *AuthorStore) Learn(authors []string, authorSorts []string) error {
+	if len(authors) != len(authorSorts) {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	changed := false
+	for i, name := range authors {
+		key, sort := getAuthorKey(name), strings.TrimSpace(authorSorts[i])
+		if key == "" || sort == "" {
+			continue
+		}
+		if j := a.findAuthority(name); j >= 0 {
+			if a.authors.Authorities[j].Sort != sort {
+				a.authors.Authorities[j].Sort = sort
+				changed = true
+			}
+			continue
+		}
+		existing, found := a.authors.Overrides[key]
+		if sort == ParseAuthorName(name).Sort() {
+			if found {
+				delete(a.authors.Overrides, key)
+				changed = true
+			}
+		} else if existing != sort {
+			a.authors.Overrides[key] = sort
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return a.save()
+}
+
+// Merge makes the names into one author, known by the name and author sort given
+// Any authorities the names already belong to are merged in too, so none of their aliases are lost
+// The new authority is passed to apply (eg to change and save the books) before anything is remembered,
+// so if apply fails the authors are left as they were and the books and authors files still agree
+// The lock isn't held while apply runs, so it can look up author sorts
+func (a *AuthorStore) Merge(name string, sort string, names []string, apply func(AuthorAuthority) error) (AuthorAuthority, error) {
+	name, sort = strings.Join(strings.Fields(name), " "), strings.TrimSpace(sort)
+	if name == "" || sort == "" {
+		return AuthorAuthority{}, errors.New("a name and author sort are required")
+	}
+
+	// Work out the authority from a copy, so nothing changes unless apply succeeds
+	a.lock.Lock()
+	merging := authorsFile{Overrides: maps.Clone(a.authors.Overrides), Authorities: slices.Clone(a.authors.Authorities)}
+	a.lock.Unlock()
+	if err := apply(merging.merge(name, sort, names)); err != nil {
+		return AuthorAuthority{}, err
+	}
+
+	// Merge again into the authors as they are now, in case they changed while apply ran
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	merged := a.authors.merge(name, sort, names)
+	return merged, a.save()
+}
+
+// merge takes out the authorities for any of the names, and adds one authority for them all
+// (see AuthorStore.Merge)
+func (f *authorsFile) merge(name string, sort string, names []string) AuthorAuthority {
+	// Take out the authorities for any of the names, keeping their names as aliases
+	merged := AuthorAuthority{Name: name, Sort: sort, Aliases: []string{}}
+	all := append([]string{name}, names...)
+	for _, n := range slices.Clone(all) {
+		if i := f.findAuthority(n); i >= 0 {
+			all = append(all, f.Authorities[i].Names()...)
+			f.Authorities = slices.Delete(f.Authorities, i, i+1)
+		}
+	}
+
+	// The aliases are every other name, without repeats
+	seen := map[string]bool{getAuthorKey(name): true}
+	for _, n := range all {
+		key := getAuthorKey(n)
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		merged.Aliases = append(merged.Aliases, strings.Join(strings.Fields(n), " "))
+		delete(f.Overrides, key)
+	}
+	delete(f.Overrides, getAuthorKey(name))
+	f.Authorities = append(f.Authorities, merged)
+	return merged
+}
+
+// findAuthority returns the position of the authority with a name or alias, or -1 if there isn't one
+// The lock must be held
+func (a *AuthorStore) findAuthority(name string) int {
+	return a.authors.findAuthority(name)
+}
+
+// findAuthority returns the position of the authority with a name or alias, or -1 if there isn't one
+func (f *authorsFile) findAuthority(name string) int {
+	key := getAuthorKey(name)
+	return slices.IndexFunc(f.Authorities, func(authority AuthorAuthority) bool {
+		return slices.ContainsFunc(authority.Names(), func(n string) bool { return getAuthorKey(n) == key })
+	})
+}
+
+// save writes the authors file, if there is one
+// The lock must be held
+func (a *AuthorStore) save() error {
+	if a.filename == "" {
+		return nil
+	}
+	content, err := json.MarshalIndent(a.authors, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(a.filename, content, 0644)
+}
+
+// getAuthorKey returns how an author's name is looked up (lower case, with spaces tidied)
+func getAuthorKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseAuthorNameSort(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Lin Carter", "Carter, Lin"},
+		{"Lee Child", "Child, Lee"},
+		{"Kim Stanley Robinson", "Robinson, Kim Stanley"},
+		{"Kim Newman", "Newman, Kim"},
+		{"Mao Zedong", "Mao Zedong"},
+		{"Deng Xiaoping", "Deng Xiaoping"},
+		{"Kim Jong-un", "Kim Jong-un"},
+		{"Park Chan-wook", "Park Chan-wook"},
+		{"Nguyen Van Thieu", "Nguyen Van Thieu"},
+		{"Lao Tzu", "Lao Tzu"},
+		{"L. Sprague de Camp", "de Camp, L Sprague"},
+		{"A. E. van Vogt", "van Vogt, AE"},
+		{"Walter de la Mare", "de la Mare, Walter"},
+		{"Johann Wolfgang von Goethe", "Goethe, Johann Wolfgang von"},
+		{"Ursula K. Le Guin", "Le Guin, Ursula K"},
+		{"Daphne du Maurier", "du Maurier, Daphne"},
+		{"Flannery O'Connor", "O'Connor, Flannery"},
+		{"Martin Luther King Jr.", "King, Martin Luther, Jr"},
+		{"John Smith III", "Smith, John, III"},
+		{"J.R.R. Tolkien", "Tolkien, JRR"},
+		{"J. R. R. Tolkien", "Tolkien, JRR"},
+		{"Tolkien, J. R. R.", "Tolkien, JRR"},
+		{"King, Martin Luther, Jr.", "King, Martin Luther, Jr"},
+		{"José Ortega y Gasset", "Ortega y Gasset, José"},
+		{"BBC Books", "BBC Books"},
+		{"Plato", "Plato"},
+		{"村上 春樹", "村上 春樹"},
+		{"  Ursula   K. Le Guin ", "Le Guin, Ursula K"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := ParseAuthorName(test.name).Sort(); got != test.want {
+			t.Errorf("ParseAuthorName(%q).Sort() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseAuthorNameFamilyFirst(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Lin Carter", false},
+		{"Lee Child", false},
+		{"Kim Stanley Robinson", false},
+		{"Mao Zedong", true},
+		{"Han Kang", true},
+		{"Kim Young-ha", true},
+		{"Ho Chi Minh", true},
+		{"Wang Wei, Jr.", false},
+		{"Lin, Carter", false},
+		{"毛泽东", true},
+		{"村上 春樹", true},
+		{"한 강", true},
+	}
+	for _, test := range tests {
+		if got := ParseAuthorName(test.name).FamilyFirst; got != test.want {
+			t.Errorf("ParseAuthorName(%q).FamilyFirst = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAuthorStoreMergeUndoneWhenApplyFails(t *testing.T) {
+	store := &AuthorStore{authors: newAuthorsFile()}
+	store.authors.Overrides["john ronald reuel tolkien"] = "Tolkien, John"
+	names := []string{"J.R.R. Tolkien", "John Ronald Reuel Tolkien"}
+
+	_, err := store.Merge("J.R.R. Tolkien", "Tolkien, JRR", names, func(AuthorAuthority) error { return errors.New("disk full") })
+	if err == nil {
+		t.Fatal("Merge() succeeded, want the apply error")
+	}
+	if len(store.Authorities()) != 0 {
+		t.Errorf("Authorities() = %v after a failed merge, want none", store.Authorities())
+	}
+	if got := store.GetAuthorSort("John Ronald Reuel Tolkien"); got != "Tolkien, John" {
+		t.Errorf("GetAuthorSort() = %q after a failed merge, want the override kept", got)
+	}
+
+	if _, err := store.Merge("J.R.R. Tolkien", "Tolkien, JRR", names, func(AuthorAuthority) error { return nil }); err != nil {
+		t.Fatalf("Merge() = %v", err)
+	}
+	if got := store.GetAuthorSort("John Ronald Reuel Tolkien"); got != "Tolkien, JRR" {
+		t.Errorf("GetAuthorSort() = %q after merging, want the authority's sort", got)
+	}
+}
+
+func TestAuthorStoreMergeWithMismatchedSorts(t *testing.T) {
+	previous := KnownAuthors
+	KnownAuthors = &AuthorStore{authors: newAuthorsFile()}
+	defer func() { KnownAuthors = previous }()
+
+	// The sorts don't match the authors, so are worked out again while merging
+	books := []Book{
+		{Authors: []string{"John Ronald Reuel Tolkien", "Christopher Tolkien"}, AuthorSort: []string{"Tolkien, John"}},
+		{Authors: []string{"J.R.R. Tolkien"}, AuthorSort: []string{"Tolkien, JRR"}},
+	}
+	names := []string{"J.R.R. Tolkien", "John Ronald Reuel Tolkien"}
+
+	done := make(chan error)
+	go func() {
+		_, err := KnownAuthors.Merge("J.R.R. Tolkien", "Tolkien, JRR", names, func(authority AuthorAuthority) error {
+			MergeAuthorsInBooks(books, authority)
+			return nil
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Merge() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Merge() didn't finish, so is probably deadlocked")
+	}
+
+	want := []string{"Tolkien, JRR", "Tolkien, Christopher"}
+	if !slices.Equal(books[0].Authors, []string{"J.R.R. Tolkien", "Christopher Tolkien"}) || !slices.Equal(books[0].AuthorSort, want) {
+		t.Errorf("merged book = %v %v, want [J.R.R. Tolkien Christopher Tolkien] %v", books[0].Authors, books[0].AuthorSort, want)
+	}
+}
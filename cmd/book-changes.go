@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// BookChanges are changes to a book's fields, from the edit page or the API
+// Fields that are nil are left as they are
+type BookChanges struct {
+	Title         *string   `json:"title,omitempty"`
+	SortTitle     *string   `json:"sortTitle,omitempty"`
+	Authors       *[]string `json:"authors,omitempty"`
+	AuthorSort    *[]string `json:"authorSort,omitempty"`
+	Genre         *[]string `json:"genre,omitempty"`
+	Series        *string   `json:"series,omitempty"`
+	Sequence      *string   `json:"sequence,omitempty"`
+	Status        *string   `json:"status,omitempty"`
+	Rating        *int      `json:"rating,omitempty"`
+	Notes         *string   `json:"notes,omitempty"`
+	PublishedDate *string   `json:"publishedDate,omitempty"`
+	Publisher     *string   `json:"publisher,omitempty"`
+	PageCount     *int      `json:"pageCount,omitempty"`
+	Language      *string   `json:"language,omitempty"`
+	Description   *string   `json:"description,omitempty"`
+}
+
+// MinimumISBNLength is the shortest ISBN accepted for a new book
+// Books without an ISBN can use any unique value of at least this length
+const MinimumISBNLength = 5
+
+// ApplyBookChanges validates the changes and applies them to the book
+// Nothing is changed if any of the changes are invalid
+func ApplyBookChanges(book *Book, changes BookChanges) error {
+	updated := *book
+
+	if changes.Title != nil {
+		updated.Title = strings.TrimSpace(*changes.Title)
+	}
+	if changes.SortTitle != nil {
+		updated.SortTitle = strings.TrimSpace(*changes.SortTitle)
+	}
+	if changes.Authors != nil {
+		updated.Authors = trimAll(*changes.Authors)
+	}
+	if changes.AuthorSort != nil {
+		updated.AuthorSort = trimAll(*changes.AuthorSort)
+	}
+	if changes.Genre != nil {
+		if len(*changes.Genre) > 2 {
+			return errors.New("a book can have at most 2 genres")
+		}
+		updated.Genre = []string{"", ""}
+		for i, genre := range *changes.Genre {
+			updated.Genre[i] = cleanGenre(genre)
+		}
+	}
+	if changes.Series != nil {
+		updated.Series = strings.TrimSpace(*changes.Series)
+	}
+	if changes.Sequence != nil {
+		updated.Sequence = strings.TrimSpace(*changes.Sequence)
+	}
+	if changes.Status != nil {
+		status, code, err := getFullStatus(*changes.Status)
+		if err != nil {
+			return err
+		}
+		updated.Status, updated.StatusIcon = status, code
+	}
+	if changes.Rating != nil {
+		if *changes.Rating < 0 || *changes.Rating > 5 {
+			return errors.New("rating must be a whole number between 0 and 5")
+		}
+		updated.Rating = *changes.Rating
+	}
+	if changes.Notes != nil {
+		updated.Notes = strings.TrimSpace(*changes.Notes)
+	}
+	if changes.PublishedDate != nil {
+		updated.PublishedDate = strings.TrimSpace(*changes.PublishedDate)
+	}
+	if changes.Publisher != nil {
+		updated.Publisher = strings.TrimSpace(*changes.Publisher)
+	}
+	if changes.PageCount != nil {
+		if *changes.PageCount < 0 {
+			return errors.New("page count cannot be negative")
+		}
+		updated.PageCount = *changes.PageCount
+	}
+	if changes.Language != nil {
+		updated.Language = strings.TrimSpace(*changes.Language)
+	}
+	if changes.Description != nil {
+		updated.Description = strings.TrimSpace(*changes.Description)
+	}
+
+	// Every book needs a title and an author sort
+	if updated.Title == "" || len(updated.AuthorSort) == 0 {
+		return errors.New("title and author sort are required")
+	}
+
+	// Note when a book moves to read or abandoned
+	if updated.IsFinished() && !book.IsFinished() {
+		updated.FinishedUtc = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	*book = updated
+	return nil
+}
+
+// NewManualBook creates a book that isn't from Google Books (eg one without an ISBN)
+func NewManualBook(isbn string, books []Book, changes BookChanges) (Book, error) {
+	isbn = strings.TrimSpace(isbn)
+	if len(isbn) < MinimumISBNLength {
+		return Book{}, fmt.Errorf("ISBN must be at least %d characters", MinimumISBNLength)
+	}
+	if _, found := findBookByISBN(books, isbn); found {
+		return Book{}, fmt.Errorf("a book with ISBN %s already exists", isbn)
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	book := Book{
+		ISBN:        isbn,
+		Genre:       []string{"", ""},
+		Status:      KnownStatuses.Initial().Full(),
+		StatusIcon:  KnownStatuses.Initial().Code,
+		AddedUtc:    now,
+		ModifiedUtc: now,
+	}
+	if changes.Authors != nil {
+		authors := fixAuthorNames(*changes.Authors)
+		if changes.AuthorSort == nil {
+			authorSort := fixAuthorSorts(authors)
+			changes.AuthorSort = &authorSort
+		}
+		changes.Authors = &authors
+	}
+	if err := ApplyBookChanges(&book, changes); err != nil {
+		return Book{}, err
+	}
+	return book, nil
+}
+
+// getFullStatus returns the full status text and code for a status, which may be
+// the full text (eg 'R - Read'), just the code (eg 'R'), or just the label (eg 'Read')
+// A blank status is allowed, meaning no status
+func getFullStatus(status string) (string, string, error) {
+	status = strings.TrimSpace(status)
+	if status == "" {
+		return "", "", nil
+	}
+	if known, ok := KnownStatuses.FindByLabel(status); ok {
+		return known.Full(), known.Code, nil
+	}
+	code, _, _ := strings.Cut(status, " ")
+	known, ok := KnownStatuses.Get(code)
+	if !ok || (len(status) > 1 && !strings.HasPrefix(status, code+" - ")) {
+		return "", "", fmt.Errorf("unknown status '%s' (use one of %s)", status, strings.Join(KnownStatuses.Codes(), ", "))
+	}
+	return known.Full(), known.Code, nil
+}
+
+// trimAll returns the non-blank values, trimmed
+func trimAll(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
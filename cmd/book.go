@@ -11,6 +11,7 @@ type Book struct {
 	ID              string   `json:"id"`
 	ISBN            string   `json:"isbn"`
 	Title           string   `json:"title"`
+	SortTitle       string   `json:"sortTitle"`
 	Authors         []string `json:"authors"`
 	Genre           []string `json:"genre"`
 	Link            string   `json:"link"`
@@ -26,7 +27,9 @@ type Book struct {
 	Rating          int      `json:"rating"`
 	Notes           string   `json:"notes"`
 	StatusIcon      string   `json:"statusIcon"`
+	AddedUtc        string   `json:"addedUtc"`
 	ModifiedUtc     string   `json:"modifiedUtc"`
+	FinishedUtc     string   `json:"finishedUtc"`
 	IsException     bool     `json:"isException"`
 	ExceptionReason string   `json:"exceptionReason"`
 }
@@ -58,6 +61,29 @@ func (b *Book) GetFirstAuthorSort() string {
 	return b.AuthorSort[0]
 }
 
+// GetSortTitle returns the title as it is sorted
+// This is the sort title if one has been given, otherwise it is worked out from the title and language
+func (b *Book) GetSortTitle() string {
+	if b.SortTitle != "" {
+		return b.SortTitle
+	}
+	return b.GetAutomaticSortTitle()
+}
+
+// GetAutomaticSortTitle returns the sort title worked out from the title and language (eg "Adversary, The")
+func (b *Book) GetAutomaticSortTitle() string {
+	return makeSortTitle(b.Title, b.Language)
+}
+
+// GetAddedUtc returns when the book was added
+// Books added before this was recorded use when they were last modified instead
+func (b *Book) GetAddedUtc() string {
+	if b.AddedUtc == "" {
+		return b.ModifiedUtc
+	}
+	return b.AddedUtc
+}
+
 // GetFirstGenre returns the first Genre of the book
 func (b *Book) GetFirstGenre() string {
 	if len(b.Genre) == 0 {
@@ -96,6 +122,27 @@ func (b *Book) GetAuthorSortHtmlDisplay() template.HTML {
 	return template.HTML(b.getHtmlLines(b.AuthorSort))
 }
 
+// GetRatingHtml returns the rating as a row of stars, or nothing if unrated
+func (b *Book) GetRatingHtml() template.HTML {
+	if b.Rating <= 0 {
+		return ""
+	}
+	var sb strings.Builder
+	for i := range 5 {
+		if i < b.Rating {
+			sb.WriteString(`<span class="star filled">&starf;</span>`)
+		} else {
+			sb.WriteString(`<span class="star">&starf;</span>`)
+		}
+	}
+	return template.HTML(sb.String())
+}
+
+// IsFinished returns true if the book's status is done with (eg read or abandoned)
+func (b *Book) IsFinished() bool {
+	return KnownStatuses.IsDone(b.StatusIcon)
+}
+
 // GetGenreDisplay returns a formatted string for displaying genres
 func (b *Book) GetGenreDisplay() string {
 	return b.getDisplayString(b.Genre)
@@ -131,7 +178,10 @@ func (b *Book) Print() {
 	grid.AddRow("Notes:", b.Notes)
 	grid.AddRow("Exception:", fmt.Sprintf("%v", b.IsException))
 	grid.AddRow("Exception Reason:", b.ExceptionReason)
+	grid.AddRow("Sort Title:", b.GetSortTitle())
+	grid.AddRow("Added:", b.GetAddedUtc())
 	grid.AddRow("Modified:", b.ModifiedUtc)
+	grid.AddRow("Finished:", b.FinishedUtc)
 
 	fmt.Println(grid)
 }
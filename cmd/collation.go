@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Collation compares text the way people expect a list of books to be ordered,
+// ignoring case and accents, and with numbers in order of value ("Book 2" before "Book 10")
+type Collation struct {
+	// Language is the language whose rules are used (eg "sv"), or empty for the default rules
+	Language string
+
+	// letters are where the language sorts letters that would otherwise lose their accents
+	letters map[rune]string
+}
+
+// BookCollation is the collation in use, which has the default rules unless the settings choose a language
+var BookCollation = Collation{}
+
+// CollationLanguages are the languages with their own rules for where some letters sort
+// Anything else uses the default rules, which treat accented letters as the plain letter
+// Letters sorting after 'z' are written as 'z' followed by DEL (which comes after every letter)
+var CollationLanguages = map[string]map[rune]string{
+	"da": nordicLetters("æä", "øö", "å"),
+	"de": {'ä': "ae", 'ö': "oe", 'ü': "ue"},
+	"es": {'ñ': "n\x7f"},
+	"fi": nordicLetters("å", "äæ", "öø"),
+	"nb": nordicLetters("æä", "øö", "å"),
+	"no": nordicLetters("æä", "øö", "å"),
+	"sv": nordicLetters("å", "äæ", "öø"),
+}
+
+// foldedLetters are the plain letters used in place of accented ones (and a few others)
+var foldedLetters = buildFoldedLetters(map[string]string{
+	"a":  "àáâãäåāăą",
+	"c":  "çćĉċč",
+	"d":  "ďđð",
+	"e":  "èéêëēĕėęě",
+	"g":  "ĝğġģ",
+	"h":  "ĥħ",
+	"i":  "ìíîïĩīĭįı",
+	"j":  "ĵ",
+	"k":  "ķ",
+	"l":  "ĺļľŀł",
+	"n":  "ñńņňŉ",
+	"o":  "òóôõöøōŏő",
+	"r":  "ŕŗř",
+	"s":  "śŝşšș",
+	"t":  "ţťŧț",
+	"u":  "ùúûüũūŭůűų",
+	"w":  "ŵ",
+	"y":  "ýÿŷ",
+	"z":  "źżž",
+	"ae": "æ",
+	"oe": "œ",
+	"ss": "ß",
+	"th": "þ",
+})
+
+// NewCollation returns the collation for a language code (eg "sv"), or the default one if it is empty
+func NewCollation(language string) (Collation, error) {
+	language = strings.ToLower(strings.TrimSpace(language))
+	if language == "" {
+		return Collation{}, nil
+	}
+	letters, ok := CollationLanguages[language]
+	if !ok {
+		languages := []string{}
+		for code := range CollationLanguages {
+			languages = append(languages, code)
+		}
+		slices.Sort(languages)
+		return Collation{}, fmt.Errorf("no collation for language '%s' (expected one of %s)", language, strings.Join(languages, ", "))
+	}
+	return Collation{Language: language, letters: letters}, nil
+}
+
+// Key returns text as it is compared (lower case, without accents, and with the language's letters)
+func (c Collation) Key(text string) string {
+	var key strings.Builder
+	for _, r := range text {
+		r = unicode.ToLower(r)
+		if letters, ok := c.letters[r]; ok {
+			key.WriteString(letters)
+		} else if letters, ok := foldedLetters[r]; ok {
+			key.WriteString(letters)
+		} else {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// Compare compares text ignoring case and accents, with runs of digits compared by value
+// Text that only differs in case or accents is then ordered by the original text, so the order is stable
+func (c Collation) Compare(a string, b string) int {
+	if result := compareNatural(c.Key(a), c.Key(b)); result != 0 {
+		return result
+	}
+	return strings.Compare(a, b)
+}
+
+// FoldText returns text lower case and without accents, as the default rules compare it
+// This is used for searching, so (for example) "bronte" finds "Brontë" whatever the collation
+func FoldText(text string) string {
+	return Collation{}.Key(text)
+}
+
+// CompareText compares text using the collation in use
+func CompareText(a string, b string) int {
+	return BookCollation.Compare(a, b)
+}
+
+// compareNatural compares text byte by byte, except that runs of digits are compared by value
+func compareNatural(a string, b string) int {
+	for a != "" && b != "" {
+		if isDigit(a[0]) && isDigit(b[0]) {
+			aEnd, bEnd := digitsLength(a), digitsLength(b)
+			if result := compareDigits(a[:aEnd], b[:bEnd]); result != 0 {
+				return result
+			}
+			a, b = a[aEnd:], b[bEnd:]
+			continue
+		}
+		if a[0] != b[0] {
+			return cmp.Compare(a[0], b[0])
+		}
+		a, b = a[1:], b[1:]
+	}
+	return cmp.Compare(len(a), len(b))
+}
+
+// compareDigits compares runs of digits by value, however long they are
+func compareDigits(a string, b string) int {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return cmp.Compare(len(a), len(b))
+	}
+	return strings.Compare(a, b)
+}
+
+// digitsLength returns how many digits the text starts with
+func digitsLength(text string) int {
+	length := 0
+	for length < len(text) && isDigit(text[length]) {
+		length++
+	}
+	return length
+}
+
+// isDigit returns true for the ASCII digits
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// nordicLetters returns letters that sort after 'z', in the order given
+// Each string is letters that sort as one (eg "äæ" in Swedish)
+func nordicLetters(groups ...string) map[rune]string {
+	letters := map[rune]string{}
+	for i, group := range groups {
+		for _, r := range group {
+			letters[r] = "z" + strings.Repeat("\x7f", i+1)
+		}
+	}
+	return letters
+}
+
+// buildFoldedLetters turns plain letters and their accented forms into a lookup by accented letter
+func buildFoldedLetters(plain map[string]string) map[rune]string {
+	letters := map[rune]string{}
+	for replacement, accented := range plain {
+		for _, r := range accented {
+			letters[r] = replacement
+		}
+	}
+	return letters
+}
+
+// sequencePattern matches a sequence number, with any parts after dots (eg "1.5") and anything after it (eg "2a")
+var sequencePattern = regexp.MustCompile(`^(\d+(?:\.\d+)*)(.*)$`)
+
+// romanSequencePattern matches a sequence that is a roman numeral, with anything after it not being a letter
+// Only I, V, X, and L are used (up to 89), so words such as "mix" aren't mistaken for numbers
+var romanSequencePattern = regexp.MustCompile(`(?i)^([ivxl]+)([^a-z].*)?$`)
+
+// SeriesSequence is a book's place in a series, worked out from its sequence
+type SeriesSequence struct {
+	// Parts are the numbers (eg "1.5" is 1 then 5, and "II" is 2)
+	Parts []int
+
+	// Rest is anything after the number (eg the "a" of "2a")
+	Rest string
+}
+
+// ParseSequence works out a book's place in a series from its sequence (eg "3", "1.5", "2a", "II", or "#4")
+// The second return value is false if the sequence doesn't start with a number
+func ParseSequence(sequence string) (SeriesSequence, bool) {
+	sequence = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(sequence), "#"))
+	if match := sequencePattern.FindStringSubmatch(sequence); match != nil {
+		parts := []int{}
+		for _, part := range strings.Split(match[1], ".") {
+			number, err := strconv.Atoi(part)
+			if err != nil {
+				return SeriesSequence{}, false
+			}
+			parts = append(parts, number)
+		}
+		return SeriesSequence{Parts: parts, Rest: strings.TrimSpace(match[2])}, true
+	}
+	if match := romanSequencePattern.FindStringSubmatch(sequence); match != nil {
+		if number := parseRomanNumeral(match[1]); number > 0 {
+			return SeriesSequence{Parts: []int{number}, Rest: strings.TrimSpace(match[2])}, true
+		}
+	}
+	return SeriesSequence{}, false
+}
+
+// Compare orders sequences by their numbers (so "1" then "1.5" then "2"), then by anything after them
+// A number on its own comes before the same number with something after it (eg "2" then "2a")
+func (s SeriesSequence) Compare(other SeriesSequence) int {
+	if result := slices.Compare(s.Parts, other.Parts); result != 0 {
+		return result
+	}
+	switch {
+	case s.Rest == other.Rest:
+		return 0
+	case s.Rest == "":
+		return -1
+	case other.Rest == "":
+		return 1
+	}
+	return CompareText(s.Rest, other.Rest)
+}
+
+// romanNumerals are the values of roman numerals, largest first, including the subtractive pairs
+var romanNumerals = []struct {
+	Value   int
+	Numeral string
+}{
+	{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"}, {100, "C"}, {90, "XC"},
+	{50, "L"}, {40, "XL"}, {10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+}
+
+// parseRomanNumeral returns the value of a roman numeral, or zero if it isn't a properly written one
+func parseRomanNumeral(text string) int {
+	text = strings.ToUpper(text)
+	value, rest := 0, text
+	for _, numeral := range romanNumerals {
+		for strings.HasPrefix(rest, numeral.Numeral) {
+			value += numeral.Value
+			rest = rest[len(numeral.Numeral):]
+		}
+	}
+	if rest != "" || formatRomanNumeral(value) != text {
+		return 0
+	}
+	return value
+}
+
+// formatRomanNumeral returns a number as a roman numeral
+func formatRomanNumeral(value int) string {
+	var numeral strings.Builder
+	for _, n := range romanNumerals {
+		for value >= n.Value {
+			numeral.WriteString(n.Numeral)
+			value -= n.Value
+		}
+	}
+	return numeral.String()
+}
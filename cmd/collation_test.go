@@ -0,0 +1,121 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCollationCompare(t *testing.T) {
+	tests := []struct {
+		language string
+		a        string
+		b        string
+		want     int
+	}{
+		{"", "Book 2", "Book 10", -1},
+		{"", "Book 10", "Book 9", 1},
+		{"", "Book 007", "Book 7", -1},
+		{"", "Book 2a", "Book 2b", -1},
+		{"", "12345678901234567890", "9", 1},
+		{"", "apple", "Banana", -1},
+		{"", "Émile", "Emily", -1},
+		{"", "Brontë", "Bronte", 1},
+		{"", "Brontë", "Brontes", -1},
+		{"", "Æsop", "Aesop", 1},
+		{"", "Öland", "Oslo", -1},
+		{"", "same", "same", 0},
+		{"sv", "Öland", "Oslo", 1},
+		{"sv", "Åsa", "Ärla", -1},
+		{"sv", "Ärla", "Örjan", -1},
+		{"sv", "Örjan", "Zebra", 1},
+		{"da", "Ærø", "Øster", -1},
+		{"da", "Øster", "Århus", -1},
+		{"de", "Müller", "Mueller", 1},
+		{"de", "Müller", "Muff", -1},
+		{"es", "Nuñez", "Nunez", 1},
+		{"es", "Nuñez", "Nunzez", 1},
+		{"es", "Nuñez", "Nuzez", -1},
+		{"es", "ñu", "oso", -1},
+	}
+	for _, test := range tests {
+		collation, err := NewCollation(test.language)
+		if err != nil {
+			t.Fatalf("NewCollation(%q) = %v", test.language, err)
+		}
+		if got := collation.Compare(test.a, test.b); got != test.want {
+			t.Errorf("Collation(%q).Compare(%q, %q) = %d, want %d", test.language, test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestNewCollationUnknownLanguage(t *testing.T) {
+	if _, err := NewCollation("xx"); err == nil {
+		t.Error("NewCollation(\"xx\") succeeded, want an error")
+	}
+	if collation, err := NewCollation(" SV "); err != nil || collation.Language != "sv" {
+		t.Errorf("NewCollation(\" SV \") = %v, %v, want sv", collation.Language, err)
+	}
+}
+
+func TestFoldText(t *testing.T) {
+	tests := map[string]string{
+		"Brontë":      "bronte",
+		"ÉMILE ZOLA":  "emile zola",
+		"Straße":      "strasse",
+		"Œuvres":      "oeuvres",
+		"Plain text1": "plain text1",
+	}
+	for text, want := range tests {
+		if got := FoldText(text); got != want {
+			t.Errorf("FoldText(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestParseSequence(t *testing.T) {
+	tests := []struct {
+		sequence string
+		want     SeriesSequence
+		numbered bool
+	}{
+		{"1", SeriesSequence{Parts: []int{1}}, true},
+		{" 1.5 ", SeriesSequence{Parts: []int{1, 5}}, true},
+		{"1.10", SeriesSequence{Parts: []int{1, 10}}, true},
+		{"2a", SeriesSequence{Parts: []int{2}, Rest: "a"}, true},
+		{"#3", SeriesSequence{Parts: []int{3}}, true},
+		{"# 4", SeriesSequence{Parts: []int{4}}, true},
+		{"II", SeriesSequence{Parts: []int{2}}, true},
+		{"iv", SeriesSequence{Parts: []int{4}}, true},
+		{"XIV (part one)", SeriesSequence{Parts: []int{14}, Rest: "(part one)"}, true},
+		{"IIII", SeriesSequence{}, false},
+		{"mix", SeriesSequence{}, false},
+		{"Lost", SeriesSequence{}, false},
+		{"prequel", SeriesSequence{}, false},
+		{"", SeriesSequence{}, false},
+	}
+	for _, test := range tests {
+		got, numbered := ParseSequence(test.sequence)
+		if numbered != test.numbered || !slices.Equal(got.Parts, test.want.Parts) || got.Rest != test.want.Rest {
+			t.Errorf("ParseSequence(%q) = %v, %v, want %v, %v", test.sequence, got, numbered, test.want, test.numbered)
+		}
+	}
+}
+
+func TestSeriesSequenceCompare(t *testing.T) {
+	ordered := []string{"1", "1.5", "1.10", "2", "II (again)", "2a", "2b", "#3", "IV", "10"}
+	for i := 1; i < len(ordered); i++ {
+		a, _ := ParseSequence(ordered[i-1])
+		b, _ := ParseSequence(ordered[i])
+		if got := a.Compare(b); got != -1 {
+			t.Errorf("Compare(%q, %q) = %d, want -1", ordered[i-1], ordered[i], got)
+		}
+		if got := b.Compare(a); got != 1 {
+			t.Errorf("Compare(%q, %q) = %d, want 1", ordered[i], ordered[i-1], got)
+		}
+	}
+	two, _ := ParseSequence("2")
+	roman, _ := ParseSequence("II")
+	if got := two.Compare(roman); got != 0 {
+		t.Errorf("Compare(\"2\", \"II\") = %d, want 0", got)
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Config holds the optional settings, loaded from a JSON file
+// Anything not in the file keeps its default value
+type Config struct {
+	// GoodreadsShelves maps status letters to Goodreads exclusive shelves for exports
+	GoodreadsShelves map[string]string `json:"goodreadsShelves"`
+
+	// IngestTokens maps device names to the tokens they use to send ISBNs to the server
+	IngestTokens map[string]string `json:"ingestTokens"`
+
+	// Webhooks are sent book events as they happen
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// Users can sign in to the website (if there are none, anyone can use it without signing in)
+	Users map[string]UserConfig `json:"users"`
+
+	// Public controls what the read-only public catalog shows
+	Public PublicConfig `json:"public"`
+
+	// Statuses are the reading statuses books can have, replacing the default ones
+	Statuses BookStatuses `json:"statuses"`
+
+	// Filters are shown in the nav in the order given, replacing the default ones
+	Filters BookFilters `json:"filters"`
+
+	// Collation is the language whose rules are used when sorting text (eg "sv")
+	// Without one, text is sorted ignoring case and accents
+	Collation string `json:"collation"`
+
+	// ServerPreferences keeps each browser's sort, filter, columns, and page size
+	// in a file on the server rather than in its cookies
+	ServerPreferences bool `json:"serverPreferences"`
+}
+
+// PublicConfig is what to leave out of the read-only public catalog, and how friends can ask to borrow books
+type PublicConfig struct {
+	OmitNotes      bool     `json:"omitNotes"`
+	OmitRatings    bool     `json:"omitRatings"`
+	OmitExceptions bool     `json:"omitExceptions"`
+	RedactStatuses []string `json:"redactStatuses"`
+
+	// BorrowLink is a mailto or web link, with {isbn} and {title} replaced for each book
+	BorrowLink string `json:"borrowLink"`
+}
+
+// UserConfig is someone who can sign in to the website
+type UserConfig struct {
+	PasswordHash string `json:"passwordHash"`
+	Role         string `json:"role"`
+}
+
+// WebhookConfig is a URL to post book events to
+type WebhookConfig struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// WantsEvent returns true if the webhook should be sent the event
+// A webhook with no events listed wants them all
+func (w WebhookConfig) WantsEvent(event string) bool {
+	return len(w.Events) == 0 || slices.Contains(w.Events, event)
+}
+
+// NewConfig returns a Config containing the default settings
+func NewConfig() *Config {
+	return &Config{
+		GoodreadsShelves: map[string]string{
+			"U": "to-read",
+			"C": "currently-reading",
+			"N": "to-read",
+			"R": "read",
+			"A": "did-not-finish",
+		},
+		IngestTokens: map[string]string{},
+		Webhooks:     []WebhookConfig{},
+		Users:        map[string]UserConfig{},
+		Statuses:     DefaultStatuses(),
+		Filters:      DefaultFilters(),
+		Public: PublicConfig{
+			OmitNotes:      true,
+			OmitExceptions: true,
+			RedactStatuses: []string{},
+		},
+	}
+}
+
+// LoadConfig loads the settings from a JSON file over the top of the defaults
+func LoadConfig(filename string) *Config {
+	config := NewConfig()
+
+	exists, f, err := CheckFileExists(filename)
+	check(err)
+	if !exists {
+		check(fmt.Errorf("config file not found: %s", filename))
+	}
+	defer f.Close()
+
+	content, err := os.ReadFile(filename)
+	check(err)
+	config.Statuses = nil // any statuses or filters replace the defaults rather than merging with them
+	config.Filters = nil
+	if err := json.Unmarshal(content, config); err != nil {
+		check(fmt.Errorf("error reading config file %s: %w", filename, err))
+	}
+
+	// Status letters are always upper case
+	shelves := map[string]string{}
+	for letter, shelf := range config.GoodreadsShelves {
+		shelves[strings.ToUpper(strings.TrimSpace(letter))] = strings.TrimSpace(shelf)
+	}
+	config.GoodreadsShelves = shelves
+
+	// Statuses need unique codes, and are then used by the books
+	if len(config.Statuses) == 0 {
+		config.Statuses = DefaultStatuses()
+	}
+	if err := config.Statuses.Clean(); err != nil {
+		check(fmt.Errorf("%w in config file %s", err, filename))
+	}
+	KnownStatuses = config.Statuses
+
+	// The collation is used whenever text is sorted
+	collation, err := NewCollation(config.Collation)
+	if err != nil {
+		check(fmt.Errorf("%w in config file %s", err, filename))
+	}
+	config.Collation, BookCollation = collation.Language, collation
+
+	// Filters need unique keys, and what they filter on must make sense
+	// The statuses of the default filters aren't checked, as they may not be in the settings' statuses
+	customFilters := len(config.Filters) > 0
+	if !customFilters {
+		config.Filters = DefaultFilters()
+	}
+	keys := map[string]bool{}
+	for i := range config.Filters {
+		if err := config.Filters[i].Clean(); err != nil {
+			check(fmt.Errorf("%w in config file %s", err, filename))
+		}
+		if keys[config.Filters[i].Key] {
+			check(fmt.Errorf("filter key '%s' is used more than once in config file %s", config.Filters[i].Key, filename))
+		}
+		keys[config.Filters[i].Key] = true
+		if !customFilters {
+			continue
+		}
+		for _, letter := range append(slices.Clone(config.Filters[i].Statuses), config.Filters[i].ExcludeStatuses...) {
+			if _, ok := config.Statuses.Get(letter); !ok && letter != "-" {
+				check(fmt.Errorf("filter '%s' has unknown status '%s' in config file %s", config.Filters[i].Key, letter, filename))
+			}
+		}
+	}
+
+	// Blank tokens would let anyone in
+	for device, token := range config.IngestTokens {
+		if strings.TrimSpace(token) == "" {
+			check(fmt.Errorf("ingest token for '%s' is blank in config file %s", device, filename))
+		}
+	}
+
+	// Webhooks need a name to show in the deliveries, a URL, and known events
+	names := map[string]bool{}
+	for i, webhook := range config.Webhooks {
+		webhook.Name = strings.TrimSpace(webhook.Name)
+		if webhook.Name == "" || names[webhook.Name] {
+			check(fmt.Errorf("webhook %d needs a unique name in config file %s", i+1, filename))
+		}
+		names[webhook.Name] = true
+		target, err := url.Parse(strings.TrimSpace(webhook.URL))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			check(fmt.Errorf("webhook '%s' needs an http or https URL in config file %s", webhook.Name, filename))
+		}
+		webhook.URL = target.String()
+		for e, event := range webhook.Events {
+			webhook.Events[e] = strings.ToLower(strings.TrimSpace(event))
+			if !slices.Contains(KnownEvents, webhook.Events[e]) {
+				check(fmt.Errorf("webhook '%s' has unknown event '%s' in config file %s (use %s)",
+					webhook.Name, event, filename, strings.Join(KnownEvents, ", ")))
+			}
+		}
+		config.Webhooks[i] = webhook
+	}
+
+	// Users need a password hash (from -hash-password) and a known role
+	for name, user := range config.Users {
+		if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "|:") {
+			check(fmt.Errorf("user '%s' has an invalid name in config file %s", name, filename))
+		}
+		if !strings.HasPrefix(user.PasswordHash, "$2") {
+			check(fmt.Errorf("user '%s' needs a passwordHash (from -hash-password) in config file %s", name, filename))
+		}
+		user.Role = strings.ToLower(strings.TrimSpace(user.Role))
+		if _, ok := roleLevels[user.Role]; !ok {
+			check(fmt.Errorf("user '%s' has unknown role '%s' in config file %s (use %s, %s, or %s)",
+				name, user.Role, filename, RoleAdmin, RoleEditor, RoleViewer))
+		}
+		config.Users[name] = user
+	}
+
+	// Redacted statuses are letters, and borrowing needs an email address or web page
+	for i, letter := range config.Public.RedactStatuses {
+		letter = strings.ToUpper(strings.TrimSpace(letter))
+		if _, ok := config.Statuses.Get(letter); !ok {
+			check(fmt.Errorf("public redactStatuses has unknown status '%s' in config file %s", letter, filename))
+		}
+		config.Public.RedactStatuses[i] = letter
+	}
+	borrow := strings.ToLower(config.Public.BorrowLink)
+	if borrow != "" && !strings.HasPrefix(borrow, "mailto:") && !strings.HasPrefix(borrow, "http://") && !strings.HasPrefix(borrow, "https://") {
+		check(fmt.Errorf("public borrowLink must be a mailto, http, or https link in config file %s", filename))
+	}
+
+	return config
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"os"
 	"time"
 
 	"github.com/gorilla/securecookie"
@@ -10,29 +14,74 @@ import (
 // CookieHandler handles cookie operations
 type CookieHandler struct {
 	sc         *securecookie.SecureCookie
+	secrets    cookieSecrets
 	altCookies bool
 }
 
-// NewCookieHandler creates a new cookie handler
-func NewCookieHandler(altCookies bool) (*CookieHandler, error) {
-	// Generate a random hash key for cookie encryption
-	hashKey := securecookie.GenerateRandomKey(32)
-	if hashKey == nil {
-		return nil, nil
-	}
+// SecretsFilename is kept next to the books file, and holds the cookie keys
+const SecretsFilename = "mfw-secrets.json"
 
-	// Generate a random block key for cookie encryption
-	blockKey := securecookie.GenerateRandomKey(32)
-	if blockKey == nil {
-		return nil, nil
+// cookieSecrets are the keys used to sign and encrypt cookies, kept so cookies survive restarts
+type cookieSecrets struct {
+	HashKey  []byte `json:"hashKey"`
+	BlockKey []byte `json:"blockKey"`
+}
+
+// NewCookieHandler creates a new cookie handler
+// The keys are kept in the secrets file (created if needed) so cookies still work after a restart
+// If there is no secrets file, new keys are used each time
+func NewCookieHandler(altCookies bool, secretsFile string) (*CookieHandler, error) {
+	secrets, err := loadCookieSecrets(secretsFile)
+	if err != nil {
+		return nil, err
 	}
 
 	return &CookieHandler{
-		sc:         securecookie.New(hashKey, blockKey),
+		sc:         securecookie.New(secrets.HashKey, secrets.BlockKey),
+		secrets:    secrets,
 		altCookies: altCookies,
 	}, nil
 }
 
+// loadCookieSecrets loads the cookie keys, creating and saving new ones if there aren't any yet
+func loadCookieSecrets(secretsFile string) (cookieSecrets, error) {
+	var secrets cookieSecrets
+	if secretsFile != "" {
+		content, err := os.ReadFile(secretsFile)
+		if err == nil {
+			if err := json.Unmarshal(content, &secrets); err != nil {
+				return secrets, fmt.Errorf("error reading secrets file %s: %w", secretsFile, err)
+			}
+			if len(secrets.HashKey) == 64 && len(secrets.BlockKey) == 32 {
+				return secrets, nil
+			}
+		} else if !os.IsNotExist(err) {
+			return secrets, err
+		}
+	}
+
+	// Generate random keys for cookie signing and encryption
+	secrets = cookieSecrets{
+		HashKey:  securecookie.GenerateRandomKey(64),
+		BlockKey: securecookie.GenerateRandomKey(32),
+	}
+	if secrets.HashKey == nil || secrets.BlockKey == nil {
+		return secrets, errors.New("unable to generate cookie keys")
+	}
+
+	// Save them for next time, readable only by the current user
+	if secretsFile != "" {
+		content, err := json.MarshalIndent(secrets, "", "  ")
+		if err != nil {
+			return secrets, err
+		}
+		if err := os.WriteFile(secretsFile, content, 0600); err != nil {
+			return secrets, fmt.Errorf("error saving secrets file %s: %w", secretsFile, err)
+		}
+	}
+	return secrets, nil
+}
+
 // SetCookie sets a secure cookie
 func (ch *CookieHandler) SetCookie(w http.ResponseWriter, name string, value string, maxAge int) error {
 	// Encode the value
@@ -85,6 +134,43 @@ func (ch *CookieHandler) GetCookie(r *http.Request, name string) (string, error)
 	return value, nil
 }
 
+// SetLaxCookie sets a signed and encrypted cookie that isn't marked secure, so it works in every browser
+// (including Safari on localhost), and that lasts as long as the maximum age rather than the usual 30 days
+func (ch *CookieHandler) SetLaxCookie(w http.ResponseWriter, name string, value string, maxAge int) error {
+	encoded, err := ch.getLaxCodec(maxAge).Encode(name, value)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    encoded,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// GetLaxCookie gets a cookie set by SetLaxCookie, with the same maximum age
+// Cookies that weren't set by this website (or have been changed) are refused
+func (ch *CookieHandler) GetLaxCookie(r *http.Request, name string, maxAge int) (string, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	if err := ch.getLaxCodec(maxAge).Decode(name, cookie.Value, &value); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+// getLaxCodec returns the codec for cookies that last as long as the maximum age
+func (ch *CookieHandler) getLaxCodec(maxAge int) *securecookie.SecureCookie {
+	return securecookie.New(ch.secrets.HashKey, ch.secrets.BlockKey).MaxAge(maxAge)
+}
+
 // DeleteCookie deletes a cookie
 func (ch *CookieHandler) DeleteCookie(w http.ResponseWriter, name string) {
 	cookie := &http.Cookie{
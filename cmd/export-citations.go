@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Citation is a single book formatted for one of the citation formats
+type Citation struct {
+	Name string
+	Text string
+}
+
+// GetCitations returns the book formatted in each of the citation formats
+func GetCitations(book Book) []Citation {
+	citations := []Citation{}
+	for _, format := range []string{"bibtex", "ris", "csljson"} {
+		exporter, err := GetExporter(format)
+		if err != nil {
+			continue
+		}
+		var sb strings.Builder
+		if err := exporter.Write(&sb, []Book{book}, ExportOptions{}); err != nil {
+			continue
+		}
+		citations = append(citations, Citation{Name: exporter.Name, Text: sb.String()})
+	}
+	return citations
+}
+
+// writeBibTeX writes the books as BibTeX @book entries
+func writeBibTeX(w io.Writer, books []Book, options ExportOptions) error {
+	books = getCitableBooks(books)
+	keys := getCitationKeys(books)
+	for i, book := range books {
+		fields := [][2]string{
+			{"author", strings.Join(getCitationAuthors(&book), " and ")},
+			{"title", "{" + escapeBibTeX(book.Title) + "}"},
+			{"publisher", escapeBibTeX(book.Publisher)},
+			{"year", getPublishedYear(book.PublishedDate)},
+			{"date", book.PublishedDate},
+			{"series", escapeBibTeX(book.Series)},
+			{"number", escapeBibTeX(book.Sequence)},
+			{"isbn", book.ISBN},
+			{"language", escapeBibTeX(book.Language)},
+			{"pagetotal", getPageCount(&book)},
+		}
+		if _, err := fmt.Fprintf(w, "@book{%s,\n", keys[i]); err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if field[1] == "" || field[1] == "{}" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "  %-9s = {%s},\n", field[0], field[1]); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "}\n\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRIS writes the books as RIS BOOK records
+func writeRIS(w io.Writer, books []Book, options ExportOptions) error {
+	books = getCitableBooks(books)
+	keys := getCitationKeys(books)
+	for i, book := range books {
+		lines := [][2]string{{"TY", "BOOK"}, {"ID", keys[i]}}
+		for _, author := range getCitationAuthors(&book) {
+			lines = append(lines, [2]string{"AU", author})
+		}
+		lines = append(lines,
+			[2]string{"TI", book.Title},
+			[2]string{"T3", book.Series},
+			[2]string{"PY", getPublishedYear(book.PublishedDate)},
+			[2]string{"DA", strings.ReplaceAll(book.PublishedDate, "-", "/")},
+			[2]string{"PB", book.Publisher},
+			[2]string{"SN", book.ISBN},
+			[2]string{"LA", book.Language},
+			[2]string{"SP", getPageCount(&book)},
+			[2]string{"ER", ""},
+		)
+		for _, line := range lines {
+			if line[1] == "" && line[0] != "ER" {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%s  - %s\n", line[0], line[1]); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cslItem is a book in CSL-JSON (as used by Zotero, Pandoc, and others)
+type cslItem struct {
+	ID               string      `json:"id"`
+	Type             string      `json:"type"`
+	Title            string      `json:"title"`
+	Author           []cslName   `json:"author,omitempty"`
+	Issued           *cslDate    `json:"issued,omitempty"`
+	Publisher        string      `json:"publisher,omitempty"`
+	CollectionTitle  string      `json:"collection-title,omitempty"`
+	CollectionNumber string      `json:"collection-number,omitempty"`
+	ISBN             string      `json:"ISBN,omitempty"`
+	Language         string      `json:"language,omitempty"`
+	NumberOfPages    json.Number `json:"number-of-pages,omitempty"`
+}
+
+// cslName is a person (family and given names) or organisation (literal) in CSL-JSON
+type cslName struct {
+	Family  string `json:"family,omitempty"`
+	Given   string `json:"given,omitempty"`
+	Literal string `json:"literal,omitempty"`
+}
+
+// cslDate is a date in CSL-JSON, as year, month, and day parts
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// writeCSLJSON writes the books as a CSL-JSON array
+func writeCSLJSON(w io.Writer, books []Book, options ExportOptions) error {
+	books = getCitableBooks(books)
+	keys := getCitationKeys(books)
+	items := make([]cslItem, 0, len(books))
+	for i, book := range books {
+		item := cslItem{
+			ID:               keys[i],
+			Type:             "book",
+			Title:            book.Title,
+			Publisher:        book.Publisher,
+			CollectionTitle:  book.Series,
+			CollectionNumber: book.Sequence,
+			ISBN:             book.ISBN,
+			Language:         book.Language,
+			NumberOfPages:    json.Number(getPageCount(&book)),
+		}
+		for _, author := range getCitationAuthors(&book) {
+			if family, given, ok := strings.Cut(author, ", "); ok {
+				item.Author = append(item.Author, cslName{Family: family, Given: given})
+			} else {
+				item.Author = append(item.Author, cslName{Literal: author})
+			}
+		}
+		if parts := getDateParts(book.PublishedDate); len(parts) > 0 {
+			item.Issued = &cslDate{DateParts: [][]int{parts}}
+		}
+		items = append(items, item)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(items)
+}
+
+// getCitableBooks returns the books that have enough details to be cited
+func getCitableBooks(books []Book) []Book {
+	citable := []Book{}
+	for _, book := range books {
+		if !book.IsException && book.Title != "" {
+			citable = append(citable, book)
+		}
+	}
+	return citable
+}
+
+// getCitationKeys returns a stable citation key for each book, like 'may1984adversary'
+// The key is made from the first author's surname, the year, and the first significant
+// title word; books that would share a key get 'b', 'c', etc added in ISBN order
+func getCitationKeys(books []Book) []string {
+	keys := make([]string, len(books))
+	isbns := map[string][]string{}
+	for i := range books {
+		keys[i] = getCitationKey(&books[i])
+		isbns[keys[i]] = append(isbns[keys[i]], books[i].ISBN)
+	}
+	for i := range books {
+		shared := isbns[keys[i]]
+		if len(shared) < 2 {
+			continue
+		}
+		SortStrings(shared, false)
+		for position, isbn := range shared {
+			if isbn == books[i].ISBN && position > 0 {
+				keys[i] += string(rune('a' + position))
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// getCitationKey returns the undisambiguated citation key for a book
+func getCitationKey(book *Book) string {
+	surname, _, _ := strings.Cut(book.GetFirstAuthorSort(), ",")
+	title := book.GetAutomaticSortTitle()
+	word := ""
+	for _, candidate := range strings.Fields(title) {
+		candidate = citationKeyPart(candidate)
+		if leadingNumber(candidate) == 0 && candidate != "" && candidate != "a" && candidate != "an" && candidate != "the" {
+			word = candidate
+			break
+		}
+	}
+	key := citationKeyPart(surname) + getPublishedYear(book.PublishedDate) + word
+	if key == "" {
+		return citationKeyPart(book.ISBN)
+	}
+	return key
+}
+
+// citationKeyPart lowercases a value and keeps only ASCII letters and digits
+// Accented letters are reduced to their base letter (eg 'é' becomes 'e')
+func citationKeyPart(value string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToLower(value) {
+		r = foldAccent(r)
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// getCitationAuthors returns the authors as 'Family, Given' names
+func getCitationAuthors(book *Book) []string {
+	authors := []string{}
+	for _, author := range book.AuthorSort {
+		if author = strings.TrimSpace(author); author != "" {
+			authors = append(authors, author)
+		}
+	}
+	return authors
+}
+
+// getPageCount returns the page count as text, or an empty string if unknown
+func getPageCount(book *Book) string {
+	if book.PageCount <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", book.PageCount)
+}
+
+// getDateParts converts a date like '2004-09-15' into its numeric parts
+func getDateParts(date string) []int {
+	parts := []int{}
+	for _, part := range strings.Split(date, "-") {
+		number := leadingNumber(part)
+		if number == 0 {
+			break
+		}
+		parts = append(parts, number)
+	}
+	return parts
+}
+
+// escapeBibTeX escapes the characters that have special meanings in BibTeX
+func escapeBibTeX(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\textbackslash{}`,
+		`{`, `\{`,
+		`}`, `\}`,
+		`&`, `\&`,
+		`%`, `\%`,
+		`$`, `\$`,
+		`#`, `\#`,
+		`_`, `\_`,
+		`~`, `\textasciitilde{}`,
+		`^`, `\textasciicircum{}`,
+	)
+	return replacer.Replace(value)
+}
+
+// foldAccent returns the unaccented form of common Latin accented letters
+func foldAccent(r rune) rune {
+	if r < 0x80 || !unicode.IsLetter(r) {
+		return r
+	}
+	for base, accented := range accentFolds {
+		if strings.ContainsRune(accented, r) {
+			return base
+		}
+	}
+	return r
+}
+
+// accentFolds lists the accented forms of each base letter
+var accentFolds = map[rune]string{
+	'a': "àáâãäåāăą",
+	'c': "çćĉċč",
+	'd': "ďđ",
+	'e': "èéêëēĕėęě",
+	'g': "ĝğġģ",
+	'i': "ìíîïĩīĭįı",
+	'l': "ĺļľŀł",
+	'n': "ñńņňŉ",
+	'o': "òóôõöøōŏő",
+	'r': "ŕŗř",
+	's': "śŝşšß",
+	't': "ţťŧ",
+	'u': "ùúûüũūŭůűų",
+	'y': "ýÿŷ",
+	'z': "źżž",
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// goodreadsHeaders are the columns of a Goodreads library export,
+// which both Goodreads and StoryGraph accept for importing
+var goodreadsHeaders = []string{
+	"Book Id", "Title", "Author", "Author l-f", "Additional Authors", "ISBN", "ISBN13",
+	"My Rating", "Average Rating", "Publisher", "Binding", "Number of Pages", "Year Published",
+	"Original Publication Year", "Date Read", "Date Added", "Bookshelves", "Bookshelves with positions",
+	"Exclusive Shelf", "My Review", "Spoiler", "Private Notes", "Read Count", "Owned Copies",
+}
+
+// writeGoodreads writes the books in the Goodreads CSV schema
+// Statuses become exclusive shelves (using the config) and genres become bookshelves
+// Books that failed their lookup are skipped as they have nothing worth exporting
+func writeGoodreads(w io.Writer, books []Book, options ExportOptions) error {
+	shelves := NewConfig().GoodreadsShelves
+	if options.Config != nil {
+		shelves = options.Config.GoodreadsShelves
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(goodreadsHeaders); err != nil {
+		return err
+	}
+	for _, book := range books {
+		if book.IsException {
+			continue
+		}
+
+		// Split the authors into the main one and any others
+		author, authorSort, additional := "", book.GetFirstAuthorSort(), []string{}
+		if len(book.Authors) > 0 {
+			author = book.Authors[0]
+			additional = book.Authors[1:]
+		} else if authorSort != "" {
+			author = unfixAuthorSort(authorSort)
+		}
+
+		// Goodreads has separate columns for the two ISBN lengths
+		isbn, isbn13 := "", ""
+		switch cleaned := cleanISBN(book.ISBN); len(cleaned) {
+		case 10:
+			isbn = cleaned
+		case 13:
+			isbn13 = cleaned
+		}
+
+		// Statuses without a shelf are treated as wanting to read
+		shelf := shelves[book.StatusIcon]
+		if shelf == "" {
+			shelf = "to-read"
+		}
+		readCount, dateRead := "0", ""
+		if shelf == "read" {
+			readCount, dateRead = "1", book.FinishedUtc
+		}
+
+		// Genres become bookshelves named in the Goodreads style (eg 'science-fiction')
+		bookshelves := []string{}
+		for _, genre := range book.Genre {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				bookshelves = append(bookshelves, goodreadsShelfName(genre))
+			}
+		}
+
+		pages := ""
+		if book.PageCount > 0 {
+			pages = fmt.Sprintf("%d", book.PageCount)
+		}
+		rating := ""
+		if book.Rating > 0 {
+			rating = fmt.Sprintf("%d", book.Rating)
+		}
+
+		row := []string{
+			"",
+			book.Title,
+			author,
+			authorSort,
+			strings.Join(additional, ", "),
+			isbn,
+			isbn13,
+			rating,
+			"",
+			book.Publisher,
+			"",
+			pages,
+			getPublishedYear(book.PublishedDate),
+			"",
+			getGoodreadsDate(dateRead),
+			getGoodreadsDate(book.GetAddedUtc()),
+			strings.Join(bookshelves, ", "),
+			"",
+			shelf,
+			"",
+			"",
+			book.Notes,
+			readCount,
+			"1",
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// goodreadsShelfName converts a genre into a shelf name like 'science-fiction'
+func goodreadsShelfName(genre string) string {
+	var sb strings.Builder
+	for _, word := range strings.FieldsFunc(strings.ToLower(genre), func(r rune) bool {
+		return r == ' ' || r == ',' || r == '/' || r == '&'
+	}) {
+		if sb.Len() > 0 {
+			sb.WriteString("-")
+		}
+		sb.WriteString(word)
+	}
+	return sb.String()
+}
+
+// getGoodreadsDate converts a UTC timestamp into a Goodreads date like '2024/03/17'
+func getGoodreadsDate(timestamp string) string {
+	date, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return ""
+	}
+	return date.Format("2006/01/02")
+}
+
+// getPublishedYear returns the year from a published date like '2004-09', or an empty string
+func getPublishedYear(publishedDate string) string {
+	if len(publishedDate) < 4 {
+		return ""
+	}
+	year := publishedDate[:4]
+	if leadingNumber(year) < 1000 {
+		return ""
+	}
+	return year
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// marcLanguages maps the two-letter codes Google Books uses to MARC language codes
+var marcLanguages = map[string]string{
+	"en": "eng", "fr": "fre", "de": "ger", "es": "spa", "it": "ita",
+	"nl": "dut", "pt": "por", "sv": "swe", "da": "dan", "no": "nor",
+	"fi": "fin", "pl": "pol", "ru": "rus", "ja": "jpn", "zh": "chi",
+	"cy": "wel", "ga": "gle", "la": "lat", "el": "gre",
+}
+
+// marcDescriptionLength limits the summary so records stay within MARC's field size
+const marcDescriptionLength = 4000
+
+// writeMarc writes the books as MARC21 (ISO 2709) records
+func writeMarc(w io.Writer, books []Book, options ExportOptions) error {
+	return writeValidatedMarc(w, books, EncodeMarc, DecodeMarc)
+}
+
+// writeMarcXML writes the books as a MARCXML collection
+func writeMarcXML(w io.Writer, books []Book, options ExportOptions) error {
+	return writeValidatedMarc(w, books, EncodeMarcXML, DecodeMarcXML)
+}
+
+// writeValidatedMarc converts the books into MARC records and encodes them,
+// then checks the output by reading it back before anything is written
+func writeValidatedMarc(
+	w io.Writer,
+	books []Book,
+	encode func([]MarcRecord) ([]byte, error),
+	decode func([]byte) ([]MarcRecord, error),
+) error {
+	records := []MarcRecord{}
+	for _, book := range books {
+		if !book.IsException {
+			records = append(records, BookToMarc(&book))
+		}
+	}
+
+	content, err := encode(records)
+	if err != nil {
+		return err
+	}
+	if err := ValidateMarc(records, content, decode); err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+	return err
+}
+
+// ValidateMarc reads encoded MARC back and checks it matches the records it came from
+func ValidateMarc(records []MarcRecord, content []byte, decode func([]byte) ([]MarcRecord, error)) error {
+	read, err := decode(content)
+	if err != nil {
+		return fmt.Errorf("MARC output could not be read back: %w", err)
+	}
+	if err := CompareMarcRecords(records, read); err != nil {
+		return fmt.Errorf("MARC output did not read back correctly: %w", err)
+	}
+	return nil
+}
+
+// BookToMarc converts a book into a MARC21 bibliographic record
+func BookToMarc(book *Book) MarcRecord {
+	// New record, language material, monograph, Unicode, minimal level, non-ISBD
+	record := MarcRecord{Leader: "00000nam a22000007  4500"}
+
+	// Control number and date modified
+	record.Fields = append(record.Fields, MarcField{Tag: "001", Value: book.ISBN})
+	if modified, err := time.Parse(time.RFC3339, book.ModifiedUtc); err == nil {
+		record.Fields = append(record.Fields, MarcField{Tag: "005", Value: modified.UTC().Format("20060102150405") + ".0"})
+	}
+	record.Fields = append(record.Fields, MarcField{Tag: "008", Value: getMarcFixedData(book)})
+
+	// ISBN
+	if isbn := cleanISBN(book.ISBN); len(isbn) == 10 || len(isbn) == 13 {
+		record.Fields = append(record.Fields, marcDataField("020", ' ', ' ', 'a', isbn))
+	}
+
+	// Main author (people are 'Surname, Forenames' and organisations have no comma)
+	authors := getCitationAuthors(book)
+	if len(authors) > 0 {
+		record.Fields = append(record.Fields, marcNameField("100", "110", authors[0]))
+	}
+
+	// Title, with the second indicator skipping any leading article when filing
+	title := book.Title
+	ind1 := byte('0')
+	if len(authors) > 0 {
+		ind1 = '1'
+	}
+	record.Fields = append(record.Fields, marcDataField("245", ind1, getMarcNonFiling(title), 'a', title))
+
+	// Publication
+	publication := MarcField{Tag: "264", Ind1: ' ', Ind2: '1'}
+	publication.Subfields = append(publication.Subfields, MarcSubfield{Code: 'a', Value: "[Place of publication not identified]"})
+	if book.Publisher != "" {
+		publication.Subfields = append(publication.Subfields, MarcSubfield{Code: 'b', Value: book.Publisher})
+	}
+	if year := getPublishedYear(book.PublishedDate); year != "" {
+		publication.Subfields = append(publication.Subfields, MarcSubfield{Code: 'c', Value: year})
+	}
+	record.Fields = append(record.Fields, publication)
+
+	// Pages
+	if book.PageCount > 0 {
+		record.Fields = append(record.Fields, marcDataField("300", ' ', ' ', 'a', fmt.Sprintf("%d pages", book.PageCount)))
+	}
+
+	// Series (untraced)
+	if book.Series != "" {
+		series := marcDataField("490", '0', ' ', 'a', book.Series)
+		if book.Sequence != "" {
+			series.Subfields = append(series.Subfields, MarcSubfield{Code: 'v', Value: book.Sequence})
+		}
+		record.Fields = append(record.Fields, series)
+	}
+
+	// Summary
+	if description := strings.TrimSpace(book.Description); description != "" {
+		record.Fields = append(record.Fields, marcDataField("520", ' ', ' ', 'a', truncateUTF8(description, marcDescriptionLength)))
+	}
+
+	// Genres as uncontrolled subject terms
+	for _, genre := range book.Genre {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			record.Fields = append(record.Fields, marcDataField("650", ' ', '4', 'a', genre))
+		}
+	}
+
+	// Additional authors
+	if len(authors) > 1 {
+		for _, author := range authors[1:] {
+			record.Fields = append(record.Fields, marcNameField("700", "710", author))
+		}
+	}
+
+	return record
+}
+
+// getMarcFixedData returns the 40-character 008 field for a book
+func getMarcFixedData(book *Book) string {
+	entered := time.Now().UTC()
+	if modified, err := time.Parse(time.RFC3339, book.ModifiedUtc); err == nil {
+		entered = modified.UTC()
+	}
+	dateType, year := "n", "uuuu"
+	if published := getPublishedYear(book.PublishedDate); published != "" {
+		dateType, year = "s", published
+	}
+	language, ok := marcLanguages[strings.ToLower(book.Language)]
+	if !ok {
+		language = "und"
+	}
+
+	// Date entered, date type and dates, place, book details, language, source
+	return entered.Format("060102") + dateType + year + "    " + "xx " + strings.Repeat(" ", 17) + language + " " + "d"
+}
+
+// getMarcNonFiling returns the count of leading article characters to skip when filing a title
+func getMarcNonFiling(title string) byte {
+	for _, article := range []string{"The ", "An ", "A "} {
+		if strings.HasPrefix(title, article) {
+			return byte('0' + len(article))
+		}
+	}
+	return '0'
+}
+
+// marcDataField returns a data field with a single subfield
+func marcDataField(tag string, ind1 byte, ind2 byte, code byte, value string) MarcField {
+	return MarcField{Tag: tag, Ind1: ind1, Ind2: ind2, Subfields: []MarcSubfield{{Code: code, Value: value}}}
+}
+
+// marcNameField returns a personal name field, or a corporate name field for organisations
+func marcNameField(personalTag string, corporateTag string, name string) MarcField {
+	if strings.Contains(name, ",") {
+		return marcDataField(personalTag, '1', ' ', 'a', name)
+	}
+	return marcDataField(corporateTag, '2', ' ', 'a', name)
+}
+
+// truncateUTF8 shortens text to at most a number of bytes without splitting a character
+func truncateUTF8(text string, maxBytes int) string {
+	if len(text) <= maxBytes {
+		return text
+	}
+	text = text[:maxBytes]
+	for len(text) > 0 && !utf8.ValidString(text) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Exporter writes books in a particular file format
+type Exporter struct {
+	Format      string
+	Name        string
+	Extension   string
+	ContentType string
+	Write       func(w io.Writer, books []Book, options ExportOptions) error
+}
+
+// ExportOptions holds the choices that apply to an export
+type ExportOptions struct {
+	// Columns are the ExportColumn names to include (where the format supports it)
+	Columns []string
+
+	// Config holds the settings used by some formats (eg Goodreads shelves)
+	Config *Config
+}
+
+// ExportColumn is a column available to tabular exports
+type ExportColumn struct {
+	Name    string
+	Header  string
+	Default bool
+	Value   func(b *Book) string
+}
+
+// Exporters are the available export formats, in the order they are offered
+var Exporters = []Exporter{
+	{Format: "csv", Name: "CSV", Extension: "csv", ContentType: "text/csv; charset=utf-8", Write: writeCSV(',')},
+	{Format: "tsv", Name: "TSV", Extension: "tsv", ContentType: "text/tab-separated-values; charset=utf-8", Write: writeCSV('\t')},
+	{Format: "bibtex", Name: "BibTeX", Extension: "bib", ContentType: "application/x-bibtex; charset=utf-8", Write: writeBibTeX},
+	{Format: "ris", Name: "RIS", Extension: "ris", ContentType: "application/x-research-info-systems; charset=utf-8", Write: writeRIS},
+	{Format: "csljson", Name: "CSL-JSON", Extension: "csl.json", ContentType: "application/vnd.citationstyles.csl+json; charset=utf-8", Write: writeCSLJSON},
+	{Format: "marc", Name: "MARC21", Extension: "mrc", ContentType: "application/marc", Write: writeMarc},
+	{Format: "marcxml", Name: "MARCXML", Extension: "marc.xml", ContentType: "application/marcxml+xml; charset=utf-8", Write: writeMarcXML},
+	{Format: "goodreads", Name: "Goodreads CSV", Extension: "goodreads.csv", ContentType: "text/csv; charset=utf-8", Write: writeGoodreads},
+}
+
+// ExportColumns are the columns available to tabular exports, in their output order
+// The headers match the names the generic import understands
+var ExportColumns = []ExportColumn{
+	{Name: "isbn", Header: "ISBN", Default: true, Value: func(b *Book) string { return b.ISBN }},
+	{Name: "title", Header: "Title", Default: true, Value: func(b *Book) string { return b.Title }},
+	{Name: "sortTitle", Header: "Sort Title", Value: func(b *Book) string { return b.SortTitle }},
+	{Name: "authors", Header: "Authors", Default: true, Value: func(b *Book) string { return joinMultiValue(b.Authors) }},
+	{Name: "authorSort", Header: "Author Sort", Default: true, Value: func(b *Book) string { return joinMultiValue(b.AuthorSort) }},
+	{Name: "series", Header: "Series", Default: true, Value: func(b *Book) string { return b.Series }},
+	{Name: "sequence", Header: "Sequence", Default: true, Value: func(b *Book) string { return b.Sequence }},
+	{Name: "genre", Header: "Genres", Default: true, Value: func(b *Book) string { return joinMultiValue(b.Genre) }},
+	{Name: "status", Header: "Status", Default: true, Value: func(b *Book) string { return b.Status }},
+	{Name: "rating", Header: "Rating", Default: true, Value: func(b *Book) string { return fmt.Sprintf("%d", b.Rating) }},
+	{Name: "publisher", Header: "Publisher", Default: true, Value: func(b *Book) string { return b.Publisher }},
+	{Name: "publishedDate", Header: "Published Date", Default: true, Value: func(b *Book) string { return b.PublishedDate }},
+	{Name: "pageCount", Header: "Page Count", Default: true, Value: func(b *Book) string { return fmt.Sprintf("%d", b.PageCount) }},
+	{Name: "language", Header: "Language", Value: func(b *Book) string { return b.Language }},
+	{Name: "description", Header: "Description", Value: func(b *Book) string { return b.Description }},
+	{Name: "notes", Header: "Notes", Value: func(b *Book) string { return b.Notes }},
+	{Name: "link", Header: "Link", Value: func(b *Book) string { return b.Link }},
+	{Name: "addedUtc", Header: "Added", Value: func(b *Book) string { return b.GetAddedUtc() }},
+	{Name: "modifiedUtc", Header: "Modified", Value: func(b *Book) string { return b.ModifiedUtc }},
+	{Name: "finishedUtc", Header: "Finished", Value: func(b *Book) string { return b.FinishedUtc }},
+	{Name: "exceptionReason", Header: "Exception", Value: func(b *Book) string { return b.ExceptionReason }},
+}
+
+// GetExporter returns the exporter for a format name (eg "csv")
+func GetExporter(format string) (Exporter, error) {
+	for _, exporter := range Exporters {
+		if strings.EqualFold(exporter.Format, strings.TrimSpace(format)) {
+			return exporter, nil
+		}
+	}
+	return Exporter{}, fmt.Errorf("unknown export format '%s' (expected one of %s)", format, strings.Join(GetExportFormats(), ", "))
+}
+
+// GetExportFormats returns the names of the export formats
+func GetExportFormats() []string {
+	formats := make([]string, 0, len(Exporters))
+	for _, exporter := range Exporters {
+		formats = append(formats, exporter.Format)
+	}
+	return formats
+}
+
+// ParseExportColumns converts a comma-separated list of column names into
+// a list of known columns, returning the default columns if the list is empty
+func ParseExportColumns(list string) ([]string, error) {
+	columns := []string{}
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		column, ok := getExportColumn(name)
+		if !ok {
+			known := []string{}
+			for _, c := range ExportColumns {
+				known = append(known, c.Name)
+			}
+			return nil, fmt.Errorf("unknown export column '%s' (expected one of %s)", name, strings.Join(known, ", "))
+		}
+		columns = append(columns, column.Name)
+	}
+	if len(columns) == 0 {
+		for _, column := range ExportColumns {
+			if column.Default {
+				columns = append(columns, column.Name)
+			}
+		}
+	}
+	return columns, nil
+}
+
+// SelectBooksByISBN returns the books with the given ISBNs, keeping the books' order
+func SelectBooksByISBN(books []Book, isbns []string) []Book {
+	wanted := map[string]bool{}
+	for _, isbn := range isbns {
+		wanted[strings.TrimSpace(isbn)] = true
+	}
+	selected := []Book{}
+	for _, book := range books {
+		if wanted[book.ISBN] {
+			selected = append(selected, book)
+		}
+	}
+	return selected
+}
+
+// GetExportFilename returns the default export filename, next to the books file
+func GetExportFilename(booksFile string, exporter Exporter) string {
+	base := strings.TrimSuffix(filepath.Base(booksFile), filepath.Ext(booksFile))
+	return filepath.Join(filepath.Dir(booksFile), base+"."+exporter.Extension)
+}
+
+// ExportToFile writes the books to a file in the exporter's format
+// The file is only written if the whole export succeeds
+func ExportToFile(filename string, books []Book, exporter Exporter, options ExportOptions) error {
+	var content bytes.Buffer
+	if err := exporter.Write(&content, books, options); err != nil {
+		return err
+	}
+	return os.WriteFile(filename, content.Bytes(), 0644)
+}
+
+// getExportColumn returns the column with the given name (case-insensitive)
+func getExportColumn(name string) (ExportColumn, bool) {
+	for _, column := range ExportColumns {
+		if strings.EqualFold(column.Name, name) {
+			return column, true
+		}
+	}
+	return ExportColumn{}, false
+}
+
+// writeCSV returns a writer for delimited text with the given separator
+// A byte order mark is included so spreadsheets recognise the file as UTF-8
+func writeCSV(separator rune) func(w io.Writer, books []Book, options ExportOptions) error {
+	return func(w io.Writer, books []Book, options ExportOptions) error {
+		if _, err := io.WriteString(w, "\ufeff"); err != nil {
+			return err
+		}
+
+		// Work out the columns
+		columns := []ExportColumn{}
+		headers := []string{}
+		for _, name := range options.Columns {
+			if column, ok := getExportColumn(name); ok {
+				columns = append(columns, column)
+				headers = append(headers, column.Header)
+			}
+		}
+
+		// Write the header then the books
+		writer := csv.NewWriter(w)
+		writer.Comma = separator
+		if err := writer.Write(headers); err != nil {
+			return err
+		}
+		for i := range books {
+			row := make([]string, len(columns))
+			for c, column := range columns {
+				row[c] = column.Value(&books[i])
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+}
+
+// joinMultiValue joins the non-blank items of a multi-valued field into one cell
+// Semicolons are used as they rarely appear in names and are understood by the import
+func joinMultiValue(items []string) string {
+	values := []string{}
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return strings.Join(values, "; ")
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedEntryLimit is the most books a feed will include
+const feedEntryLimit = 50
+
+// BookFeed is a list of recent books, most recent first
+type BookFeed struct {
+	Name    string
+	Title   string
+	Query   url.Values
+	Books   []Book
+	GetDate func(b *Book) time.Time
+}
+
+// bookFeeds are the available feeds with the date each one is ordered by
+var bookFeeds = map[string]BookFeed{
+	"added": {
+		Title:   "Recently Added Books",
+		GetDate: getAddedTime,
+	},
+	"finished": {
+		Title:   "Recently Finished Books",
+		GetDate: getFinishedTime,
+	},
+}
+
+// feedContentTemplate renders the body of a feed entry
+var feedContentTemplate = template.Must(template.New("entry").Parse(
+	`<p>{{.GetAuthorDisplay}}{{if .Series}}<br/>{{.GetSeriesSort}}{{end}}</p>` +
+		`{{if .IsFinished}}<p>{{.Status}}</p>{{end}}` +
+		`{{if .Rating}}<p class="rating" title="{{.Rating}} out of 5">{{.GetRatingHtml}} {{.Rating}}/5</p>{{end}}` +
+		`{{if .Description}}<p>{{.Description}}</p>{{end}}`,
+))
+
+// GetBookFeed returns the named feed ("added" or "finished") for the books,
+// limited to any genre or series given in the query
+func GetBookFeed(name string, query url.Values, books []Book) (BookFeed, error) {
+	feed, ok := bookFeeds[name]
+	if !ok {
+		return feed, fmt.Errorf("unknown feed '%s'", name)
+	}
+	feed.Name, feed.Query = name, query
+	genre := strings.TrimSpace(query.Get("genre"))
+	series := strings.TrimSpace(query.Get("series"))
+
+	// Only books with a date for this feed are included
+	feed.Books = []Book{}
+	for _, book := range books {
+		if book.IsException || feed.GetDate(&book).IsZero() {
+			continue
+		}
+		if genre != "" && !containsFold(book.Genre, genre) {
+			continue
+		}
+		if series != "" && !strings.EqualFold(book.Series, series) {
+			continue
+		}
+		feed.Books = append(feed.Books, book)
+	}
+
+	// Most recent first
+	sort.SliceStable(feed.Books, func(i, j int) bool {
+		return feed.GetDate(&feed.Books[i]).After(feed.GetDate(&feed.Books[j]))
+	})
+	if len(feed.Books) > feedEntryLimit {
+		feed.Books = feed.Books[:feedEntryLimit]
+	}
+
+	// Say what the feed has been limited to
+	if genre != "" {
+		feed.Title += " - " + genre
+	}
+	if series != "" {
+		feed.Title += " - " + series
+	}
+	return feed, nil
+}
+
+// WriteBookFeed writes the feed as Atom, with links relative to the base URL
+func WriteBookFeed(w io.Writer, feed BookFeed, baseURL string) error {
+	selfURL := baseURL + "/feeds/" + feed.Name + ".atom"
+	if len(feed.Query) > 0 {
+		selfURL += "?" + feed.Query.Encode()
+	}
+	updated := time.Now().UTC()
+	if len(feed.Books) > 0 {
+		updated = feed.GetDate(&feed.Books[0])
+	}
+
+	x := atomFeed{
+		Xmlns:   "http://www.w3.org/2005/Atom",
+		ID:      selfURL,
+		Title:   feed.Title,
+		Updated: updated.Format(time.RFC3339),
+		Author:  atomAuthor{Name: "MFW Books DB"},
+		Links: []atomLink{
+			{Rel: "self", Href: selfURL, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: baseURL + "/", Type: "text/html"},
+		},
+	}
+	for _, book := range feed.Books {
+		var content bytes.Buffer
+		if err := feedContentTemplate.Execute(&content, &book); err != nil {
+			return err
+		}
+		entry := atomEntry{
+			Title:   book.Title,
+			ID:      fmt.Sprintf("urn:isbn:%s:%s:%s", book.ISBN, feed.Name, feed.GetDate(&book).Format("20060102T150405Z")),
+			Updated: feed.GetDate(&book).Format(time.RFC3339),
+			Content: &atomText{Type: "html", Value: content.String()},
+			Links:   []atomLink{{Rel: "alternate", Href: baseURL + "/books/view/" + url.PathEscape(book.ISBN), Type: "text/html"}},
+		}
+		for _, author := range getOpdsAuthors(&book) {
+			entry.Authors = append(entry.Authors, atomAuthor{Name: author.Name})
+		}
+		for _, genre := range getOpdsGenres(&book) {
+			entry.Categories = append(entry.Categories, atomCategory{Term: genre, Label: genre})
+		}
+		x.Entries = append(x.Entries, entry)
+	}
+
+	content, err := xml.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+// getAddedTime returns when the book was added, or the zero time if unknown
+func getAddedTime(book *Book) time.Time {
+	added, err := time.Parse(time.RFC3339, book.GetAddedUtc())
+	if err != nil {
+		return time.Time{}
+	}
+	return added.UTC()
+}
+
+// getFinishedTime returns when a finished book was read or abandoned, or the zero time
+func getFinishedTime(book *Book) time.Time {
+	if !book.IsFinished() {
+		return time.Time{}
+	}
+	finished, err := time.Parse(time.RFC3339, book.FinishedUtc)
+	if err != nil {
+		return time.Time{}
+	}
+	return finished.UTC()
+}
+
+// containsFold returns true if any of the values matches the text, ignoring case
+func containsFold(values []string, text string) bool {
+	for _, value := range values {
+		if strings.EqualFold(strings.TrimSpace(value), text) {
+			return true
+		}
+	}
+	return false
+}
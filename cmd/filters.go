@@ -1,5 +1,12 @@
 package main
 
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
 // BookFilter represents a filtered view of books
 type BookFilter struct {
 	// Name is the display name of the filter (e.g. "All Books", "Unread Books", etc.)
@@ -13,108 +20,144 @@ type BookFilter struct {
 	Populate func(source []Book)
 }
 
-// GetPopulatedAllBooksFilter returns a BookFilter that contains all books
-func GetPopulatedAllBooksFilter(books []Book) BookFilter {
-	var filter BookFilter
-	filter = BookFilter{
-		Name: "All Books",
-		Populate: func(source []Book) {
-			// Simply copy all books from the source
-			filter.Books = make([]Book, len(source))
-			copy(filter.Books, source)
-		},
-	}
-	// Populate the filter with the provided books
-	filter.Populate(books)
-	return filter
+// FilterConfig is a named filter shown in the nav, in the order given in the settings
+// A book is in the filter if it matches everything the filter specifies (a filter
+// that specifies nothing has every book)
+type FilterConfig struct {
+	// Key is used in links and files (eg "reading"), and Name is shown (eg "Reading")
+	Key  string `json:"key"`
+	Name string `json:"name"`
+
+	// Statuses are the status letters to include, and ExcludeStatuses those to leave out
+	// Books with no status have the letter "-"
+	Statuses        []string `json:"statuses,omitempty"`
+	ExcludeStatuses []string `json:"excludeStatuses,omitempty"`
+
+	// Done limits the books to those whose status is done with (true) or not (false)
+	Done *bool `json:"done,omitempty"`
+
+	// Genres are the genres to include (any of them, ignoring case)
+	Genres []string `json:"genres,omitempty"`
+
+	// MinRating and MaxRating limit the rating (inclusive)
+	MinRating *int `json:"minRating,omitempty"`
+	MaxRating *int `json:"maxRating,omitempty"`
+
+	// Query is a search the books must match (see ParseBookQuery)
+	Query string `json:"query,omitempty"`
 }
 
-// GetPopulatedReadingFilter returns a BookFilter that contains only books currently being read
-func GetPopulatedReadingFilter(books []Book) BookFilter {
-	var filter BookFilter
-	filter = BookFilter{
-		Name: "Reading",
-		Populate: func(source []Book) {
-			filter.Books = filterByStatusIcon(source, "C")
-		},
+// BookFilters are the filters, in the order they are shown
+type BookFilters []FilterConfig
+
+// filterKeyPattern is what a filter key can contain, as it is used in links and file names
+var filterKeyPattern = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// DefaultFilters returns the filters used when the settings don't have any
+func DefaultFilters() BookFilters {
+	done, notDone := true, false
+	return BookFilters{
+		{Key: "all", Name: "All Books"},
+		{Key: "reading", Name: "Reading", Statuses: []string{"C"}},
+		{Key: "next", Name: "Next", Statuses: []string{"N"}},
+		{Key: "done", Name: "Done", Done: &done},
+		{Key: "other", Name: "Other", ExcludeStatuses: []string{"C", "N", "-"}, Done: &notDone},
 	}
-	// Populate the filter with the provided books
-	filter.Populate(books)
-	return filter
 }
 
-// GetPopulatedNextFilter returns a BookFilter that contains only books marked as next to read
-func GetPopulatedNextFilter(books []Book) BookFilter {
-	var filter BookFilter
-	filter = BookFilter{
-		Name: "Next",
-		Populate: func(source []Book) {
-			filter.Books = filterByStatusIcon(source, "N")
-		},
+// Keys returns the keys of the filters, in the order they are shown
+func (f BookFilters) Keys() []string {
+	keys := []string{}
+	for _, filter := range f {
+		keys = append(keys, filter.Key)
 	}
-	// Populate the filter with the provided books
-	filter.Populate(books)
-	return filter
+	return keys
 }
 
-// GetPopulatedDoneFilter returns a BookFilter that contains only completed books
-func GetPopulatedDoneFilter(books []Book) BookFilter {
-	var filter BookFilter
-	filter = BookFilter{
-		Name: "Done",
-		Populate: func(source []Book) {
-			filter.Books = filterByStatusIcon(source, "R", "A")
-		},
-	}
-	// Populate the filter with the provided books
-	filter.Populate(books)
-	return filter
+// Has returns true if there is a filter with the key (ignoring case)
+func (f BookFilters) Has(key string) bool {
+	return slices.ContainsFunc(f, func(filter FilterConfig) bool { return strings.EqualFold(filter.Key, key) })
 }
 
-// GetPopulatedOtherFilter returns a BookFilter that contains books with other statuses
-func GetPopulatedOtherFilter(books []Book) BookFilter {
-	var filter BookFilter
-	filter = BookFilter{
-		Name: "Other",
-		Populate: func(source []Book) {
-			// Get all unique status icons from the source
-			statusMap := make(map[string]bool)
-			for _, book := range source {
-				if book.StatusIcon != "" {
-					statusMap[book.StatusIcon] = true
+// GetPopulatedFilter returns the populated BookFilter for a filter key (eg "reading")
+// The second return value is false if there is no filter with that key
+func (f BookFilters) GetPopulatedFilter(key string, books []Book) (BookFilter, bool) {
+	for _, config := range f {
+		if !strings.EqualFold(config.Key, key) {
+			continue
+		}
+		var filter BookFilter
+		filter = BookFilter{
+			Name: config.Name,
+			Populate: func(source []Book) {
+				// The query was checked when the settings were loaded
+				query, _ := ParseBookQuery(config.Query)
+				filter.Books = []Book{}
+				for i := range source {
+					if config.Matches(&source[i], query) {
+						filter.Books = append(filter.Books, source[i])
+					}
 				}
-			}
-			// Remove the main status icons
-			delete(statusMap, "C") // Current
-			delete(statusMap, "N") // Next
-			delete(statusMap, "R") // Read
-			delete(statusMap, "A") // Abandoned
-			// Convert remaining status icons to slice
-			otherStatuses := make([]string, 0, len(statusMap))
-			for status := range statusMap {
-				otherStatuses = append(otherStatuses, status)
-			}
-			// Use the private function to filter by the remaining status icons
-			filter.Books = filterByStatusIcon(source, otherStatuses...)
-		},
+			},
+		}
+		// Populate the filter with the provided books
+		filter.Populate(books)
+		return filter, true
+	}
+	return BookFilter{}, false
+}
+
+// Matches returns true if the book is in the filter, using the filter's parsed query
+func (f FilterConfig) Matches(book *Book, query BookQuery) bool {
+	letter := book.GetStatusLetter()
+	if len(f.Statuses) > 0 && !slices.Contains(f.Statuses, letter) {
+		return false
+	}
+	if slices.Contains(f.ExcludeStatuses, letter) {
+		return false
+	}
+	if f.Done != nil && book.IsFinished() != *f.Done {
+		return false
+	}
+	if len(f.Genres) > 0 && !slices.ContainsFunc(book.Genre, func(genre string) bool {
+		return genre != "" && slices.ContainsFunc(f.Genres, func(wanted string) bool { return strings.EqualFold(genre, wanted) })
+	}) {
+		return false
+	}
+	if f.MinRating != nil && book.Rating < *f.MinRating {
+		return false
+	}
+	if f.MaxRating != nil && book.Rating > *f.MaxRating {
+		return false
 	}
-	// Populate the filter with the provided books
-	filter.Populate(books)
-	return filter
+	return query.Matches(book)
 }
 
-// filterByStatusIcon returns a slice of books that match any of the provided status icons
-func filterByStatusIcon(source []Book, statusIcons ...string) []Book {
-	// Create a slice to hold the filtered books
-	filtered := make([]Book, 0)
-	// Add only books with matching StatusIcon
-	for _, book := range source {
-		for _, icon := range statusIcons {
-			if book.StatusIcon == icon {
-				filtered = append(filtered, book)
-				break
-			}
+// Clean tidies the filter's details, and returns an error if it can't be used
+func (f *FilterConfig) Clean() error {
+	f.Key = strings.ToLower(strings.TrimSpace(f.Key))
+	f.Name = strings.TrimSpace(f.Name)
+	if !filterKeyPattern.MatchString(f.Key) {
+		return fmt.Errorf("filter key '%s' can only have letters, numbers, and dashes", f.Key)
+	}
+	if f.Key == "index" || slices.Contains(IndexKeys, f.Key) {
+		return fmt.Errorf("filter key '%s' is used by a published page", f.Key)
+	}
+	if f.Name == "" {
+		return fmt.Errorf("filter '%s' needs a name", f.Key)
+	}
+	for _, statuses := range [][]string{f.Statuses, f.ExcludeStatuses} {
+		for i, status := range statuses {
+			statuses[i] = strings.ToUpper(strings.TrimSpace(status))
 		}
 	}
-	return filtered
+	for _, rating := range []*int{f.MinRating, f.MaxRating} {
+		if rating != nil && (*rating < 0 || *rating > 5) {
+			return fmt.Errorf("filter '%s' has a rating outside 0 to 5", f.Key)
+		}
+	}
+	if _, err := ParseBookQuery(f.Query); err != nil {
+		return fmt.Errorf("filter '%s' has a query that can't be understood: %w", f.Key, err)
+	}
+	return nil
 }
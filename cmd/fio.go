@@ -45,7 +45,7 @@ func LoadFile(filename string) []Book {
 // SaveFile saves books to a JSON file
 func SaveFile(filename string, books []Book) error {
 	// Sort the books
-	SortBooksByTitle(books, false)
+	SortBooks(books, []SortLevel{{Field: "title"}})
 
 	// Ensure array fields are never null and copy authorSort to authors if needed
 	for i := range books {
@@ -86,9 +86,8 @@ func SaveFile(filename string, books []Book) error {
 	if err != nil {
 		check(err)
 	}
-	err = os.WriteFile(filename, json, 0644)
-	if err != nil {
-		check(err)
+	if err := writeFileAtomically(filename, json); err != nil {
+		return err
 	}
 
 	// Save a backup version
@@ -105,6 +104,47 @@ func SaveFile(filename string, books []Book) error {
 	return nil
 }
 
+// writeFileAtomically writes to a temporary file next to the file, then renames it into place,
+// so anything reading the file never sees it half written
+func writeFileAtomically(filename string, content []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name()) // Does nothing once it has been renamed
+	if _, err := temp.Write(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filename)
+}
+
+// RestoreTitles puts back the "The " that used to be moved to the end of titles when they were stored
+// (eg "Adversary, the" becomes "The Adversary"), as that is now only done when sorting
+// It returns how many titles were changed
+func RestoreTitles(filename string) (int, error) {
+	books := LoadFile(filename)
+	restored := 0
+	for i := range books {
+		if title := restoreTitle(books[i].Title); title != books[i].Title {
+			books[i].Title = title
+			restored++
+		}
+	}
+	if restored > 0 {
+		if err := SaveFile(filename, books); err != nil {
+			return 0, err
+		}
+	}
+	return restored, nil
+}
+
 // LoadISBNs reads ISBNs from a text file, one per line
 func LoadISBNs(filename string) []string {
 	exists, f, err := CheckFileExists(filename)
@@ -114,9 +154,15 @@ func LoadISBNs(filename string) []string {
 	}
 	defer f.Close()
 
-	// Read the file into a string and split it into lines
-	content := readFileNormalisedToLF(filename)
-	lines := strings.Split(content, "\n")
+	// Read the file into a string and get the ISBNs from it
+	isbns, err := ParseISBNs(readFileNormalisedToLF(filename))
+	check(err)
+	return isbns
+}
+
+// ParseISBNs reads ISBNs from text, one per line, ignoring blank lines and spaces
+func ParseISBNs(content string) ([]string, error) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
 
 	// Trim each line and add it to a slice if it's not empty
 	lineNumber := 0
@@ -126,7 +172,7 @@ func LoadISBNs(filename string) []string {
 		line = strings.TrimSpace(strings.ReplaceAll(line, " ", ""))
 		if line != "" {
 			if len(line) < 7 || len(line) > 13 {
-				check(fmt.Errorf("invalid-looking ISBN on line %d: %s", lineNumber, line))
+				return nil, fmt.Errorf("invalid-looking ISBN on line %d: %s", lineNumber, line)
 			}
 
 			// Commented this out pending further thought as some ISBNs are not numeric
@@ -139,11 +185,11 @@ func LoadISBNs(filename string) []string {
 		}
 	}
 
-	return isbns
+	return isbns, nil
 }
 
 // ClearErroredBooks removes books marked as exceptions from the file
-func ClearErroredBooks(filename string) (int, error) {
+func ClearErroredBooks(filename string, webhooks *Webhooks) (int, error) {
 	// Load the current books
 	books := LoadFile(filename)
 	originalCount := len(books)
@@ -160,7 +206,7 @@ func ClearErroredBooks(filename string) (int, error) {
 
 	// Save if we removed any books
 	if len(books) < originalCount {
-		if err := SaveFile(filename, books); err != nil {
+		if err := SaveBooks(filename, books, webhooks); err != nil {
 			return 0, err
 		}
 		return originalCount - len(books), nil
@@ -5,4 +5,5 @@ go 1.23.4
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/securecookie v1.1.2
+	golang.org/x/crypto v0.31.0
 )
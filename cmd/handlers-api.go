@@ -0,0 +1,486 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Paging limits for the API book list
+const (
+	ApiDefaultPageSize = 50
+	ApiMaximumPageSize = 500
+)
+
+// IngestMaximumBytes limits the size of a request sending ISBNs
+const IngestMaximumBytes = 1 << 20
+
+// ApiBookList is a page of books from the API
+type ApiBookList struct {
+	Books      []Book `json:"books"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"pageSize"`
+	Total      int    `json:"total"`
+	TotalPages int    `json:"totalPages"`
+}
+
+// ApiStats summarises the collection
+type ApiStats struct {
+	Total         int            `json:"total"`
+	Exceptions    int            `json:"exceptions"`
+	ByStatus      map[string]int `json:"byStatus"`
+	ByGenre       map[string]int `json:"byGenre"`
+	Authors       int            `json:"authors"`
+	Series        int            `json:"series"`
+	Rated         int            `json:"rated"`
+	AverageRating float64        `json:"averageRating"`
+	PagesRead     int            `json:"pagesRead"`
+}
+
+// ApiError is the body of every API error response
+type ApiError struct {
+	Error string `json:"error"`
+}
+
+// ApiIngestResponse says what happened to the ISBNs sent for ingesting
+type ApiIngestResponse struct {
+	Device   string   `json:"device"`
+	Job      int      `json:"job,omitempty"`
+	Queued   []string `json:"queued"`
+	Existing []string `json:"existing"`
+	Pending  int      `json:"pending"`
+}
+
+// apiCreateRequest is the body for adding a book manually
+type apiCreateRequest struct {
+	ISBN string `json:"isbn"`
+	BookChanges
+}
+
+// apiLookupRequest is the body for adding a book by looking up its ISBN
+type apiLookupRequest struct {
+	ISBN string `json:"isbn"`
+}
+
+// ApiListBooksHandler returns a page of books, optionally filtered, sorted, and searched
+func (s *Server) ApiListBooksHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	books := s.LoadBooks()
+
+	// Filter
+	if filterKey := query.Get("filter"); filterKey != "" {
+		filter, ok := s.Config.Filters.GetPopulatedFilter(filterKey, books)
+		if !ok {
+			writeApiError(w, http.StatusBadRequest, "unknown filter '%s' (expected one of %s)", filterKey, strings.Join(s.Config.Filters.Keys(), ", "))
+			return
+		}
+		books = filter.Books
+	}
+
+	// Search
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		found, err := SearchBooks(books, q)
+		if err != nil {
+			writeApiError(w, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		books = found
+	}
+
+	// Sort
+	// More than one field can be given (eg ?sort=genre,rating&dir=asc,desc)
+	if query.Get("sort") != "" {
+		levels, err := ParseSortLevels(strings.Join(query["sort"], ","), strings.Join(query["dir"], ","))
+		if err != nil {
+			writeApiError(w, http.StatusBadRequest, "%s", err.Error())
+			return
+		}
+		SortBooks(books, levels)
+	}
+
+	// Page
+	page, pageSize := 1, ApiDefaultPageSize
+	if value := query.Get("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			writeApiError(w, http.StatusBadRequest, "page must be a whole number from 1")
+			return
+		}
+		page = number
+	}
+	if value := query.Get("pageSize"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > ApiMaximumPageSize {
+			writeApiError(w, http.StatusBadRequest, "pageSize must be a whole number from 1 to %d", ApiMaximumPageSize)
+			return
+		}
+		pageSize = number
+	}
+	list := ApiBookList{
+		Books:      []Book{},
+		Page:       page,
+		PageSize:   pageSize,
+		Total:      len(books),
+		TotalPages: (len(books) + pageSize - 1) / pageSize,
+	}
+	if start := (page - 1) * pageSize; start < len(books) {
+		list.Books = books[start:min(start+pageSize, len(books))]
+	}
+
+	writeApiJson(w, http.StatusOK, list)
+}
+
+// ApiGetBookHandler returns a single book
+func (s *Server) ApiGetBookHandler(w http.ResponseWriter, r *http.Request) {
+	isbn := mux.Vars(r)["isbn"]
+	book, found := findBookByISBN(s.LoadBooks(), isbn)
+	if !found {
+		writeApiError(w, http.StatusNotFound, "book %s not found", isbn)
+		return
+	}
+	writeApiJson(w, http.StatusOK, book)
+}
+
+// ApiCreateBookHandler adds a book from the details given, without looking it up
+func (s *Server) ApiCreateBookHandler(w http.ResponseWriter, r *http.Request) {
+	var request apiCreateRequest
+	if !readApiJson(w, r, &request) {
+		return
+	}
+
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books := LoadFile(s.Filename)
+	if _, found := findBookByISBN(books, strings.TrimSpace(request.ISBN)); found {
+		writeApiError(w, http.StatusConflict, "a book with ISBN %s already exists", request.ISBN)
+		return
+	}
+	book, err := NewManualBook(request.ISBN, books, request.BookChanges)
+	if err != nil {
+		writeApiError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+		return
+	}
+
+	books = append(books, book)
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
+		writeApiError(w, http.StatusInternalServerError, "error saving file: %s", err.Error())
+		return
+	}
+	writeApiJson(w, http.StatusCreated, book)
+}
+
+// ApiLookupBookHandler adds a book by looking up its ISBN in Google Books
+func (s *Server) ApiLookupBookHandler(w http.ResponseWriter, r *http.Request) {
+	var request apiLookupRequest
+	if !readApiJson(w, r, &request) {
+		return
+	}
+	isbn := strings.TrimSpace(request.ISBN)
+	if isbn == "" {
+		writeApiError(w, http.StatusUnprocessableEntity, "isbn is required")
+		return
+	}
+
+	// The lookup is slow, so the books aren't locked while it happens
+	s.BooksLock.RLock()
+	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
+	book, found, err := lookupBook(isbn, books, false) // Use double-hit mode for better data
+	if found {
+		writeApiJson(w, http.StatusConflict, book)
+		return
+	}
+	if err != nil {
+		writeApiError(w, http.StatusNotFound, "book %s could not be found: %s", isbn, err.Error())
+		return
+	}
+
+	// Add it to the latest books, unless it was added while being looked up
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books = LoadFile(s.Filename)
+	if existing, found := findBookByISBN(books, isbn); found {
+		writeApiJson(w, http.StatusConflict, existing)
+		return
+	}
+	books = append(books, book)
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
+		writeApiError(w, http.StatusInternalServerError, "error saving file: %s", err.Error())
+		return
+	}
+	writeApiJson(w, http.StatusCreated, book)
+}
+
+// ApiUpdateBookHandler changes the fields given for a book, leaving the others as they are
+func (s *Server) ApiUpdateBookHandler(w http.ResponseWriter, r *http.Request) {
+	var changes BookChanges
+	if !readApiJson(w, r, &changes) {
+		return
+	}
+
+	isbn := mux.Vars(r)["isbn"]
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books := LoadFile(s.Filename)
+	index := findBookIndex(books, isbn)
+	if index == -1 {
+		writeApiError(w, http.StatusNotFound, "book %s not found", isbn)
+		return
+	}
+	if err := ApplyBookChanges(&books[index], changes); err != nil {
+		writeApiError(w, http.StatusUnprocessableEntity, "%s", err.Error())
+		return
+	}
+
+	book := books[index]
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
+		writeApiError(w, http.StatusInternalServerError, "error saving file: %s", err.Error())
+		return
+	}
+	writeApiJson(w, http.StatusOK, book)
+}
+
+// ApiDeleteBookHandler removes a book
+func (s *Server) ApiDeleteBookHandler(w http.ResponseWriter, r *http.Request) {
+	isbn := mux.Vars(r)["isbn"]
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books := LoadFile(s.Filename)
+	index := findBookIndex(books, isbn)
+	if index == -1 {
+		writeApiError(w, http.StatusNotFound, "book %s not found", isbn)
+		return
+	}
+
+	books = append(books[:index], books[index+1:]...)
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
+		writeApiError(w, http.StatusInternalServerError, "error saving file: %s", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ApiStatsHandler returns a summary of the collection
+func (s *Server) ApiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	books := s.LoadBooks()
+	stats := ApiStats{
+		Total:    len(books),
+		ByStatus: map[string]int{},
+		ByGenre:  map[string]int{},
+		Authors:  len(GetIndexEntries(books, "authors")),
+		Series:   len(GetIndexEntries(books, "series")),
+	}
+	totalRating := 0
+	for _, book := range books {
+		if book.IsException {
+			stats.Exceptions++
+			continue
+		}
+		if book.StatusIcon != "" {
+			stats.ByStatus[book.StatusIcon]++
+		}
+		for _, genre := range getOpdsGenres(&book) {
+			stats.ByGenre[genre]++
+		}
+		if book.Rating > 0 {
+			stats.Rated++
+			totalRating += book.Rating
+		}
+		if book.IsFinished() {
+			stats.PagesRead += book.PageCount
+		}
+	}
+	if stats.Rated > 0 {
+		stats.AverageRating = math.Round(float64(totalRating)/float64(stats.Rated)*100) / 100
+	}
+	writeApiJson(w, http.StatusOK, stats)
+}
+
+// ApiOpenApiHandler returns the OpenAPI document describing the API
+func (s *Server) ApiOpenApiHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	http.ServeFile(w, r, "api/openapi.json")
+}
+
+// IngestHandler queues ISBNs sent by a device (eg a scanner app) for looking up
+// The ISBNs can be plain text (one per line), JSON, or form data
+func (s *Server) IngestHandler(w http.ResponseWriter, r *http.Request) {
+	device, ok := s.getIngestDevice(r)
+	if !ok {
+		writeApiError(w, http.StatusUnauthorized, "a valid ingest token is required")
+		return
+	}
+
+	// Get the ISBNs in whichever format they were sent
+	r.Body = http.MaxBytesReader(w, r.Body, IngestMaximumBytes)
+	isbns, err := readIngestISBNs(r)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "%s", err.Error())
+		return
+	}
+	if len(isbns) == 0 {
+		writeApiError(w, http.StatusBadRequest, "no ISBNs were found")
+		return
+	}
+
+	// Only queue the ones we don't already have
+	response := ApiIngestResponse{Device: device, Queued: []string{}, Existing: []string{}}
+	s.BooksLock.RLock()
+	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
+	for _, isbn := range isbns {
+		if _, found := findBookByISBN(books, isbn); found {
+			response.Existing = append(response.Existing, isbn)
+		} else {
+			response.Queued = append(response.Queued, isbn)
+		}
+	}
+	if len(response.Queued) > 0 {
+		job, err := s.Jobs.Add(response.Queued, device, true)
+		if err != nil {
+			writeApiError(w, http.StatusServiceUnavailable, "%s", err.Error())
+			return
+		}
+		response.Job = job
+	}
+	response.Pending = s.Jobs.Pending()
+
+	writeApiJson(w, http.StatusAccepted, response)
+}
+
+// getIngestDevice returns the name of the device whose token was given
+// The token can be a bearer token or (for apps that can only share to a URL) a 'token' query value
+func (s *Server) getIngestDevice(r *http.Request) (string, bool) {
+	token := r.URL.Query().Get("token")
+	if bearer, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		token = bearer
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", false
+	}
+	for device, deviceToken := range s.Config.IngestTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(deviceToken)) == 1 {
+			return device, true
+		}
+	}
+	return "", false
+}
+
+// readIngestISBNs gets the ISBNs from a request, checking them in the same way as an ISBNs file
+// JSON can be a list of ISBNs, or an object with an 'isbn' or 'isbns' value
+// Form data can have 'isbn' values, 'isbns' text, or an uploaded 'file'
+func readIngestISBNs(r *http.Request) ([]string, error) {
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	values := []string{}
+	switch mediaType {
+	case "application/json":
+		if strings.HasPrefix(strings.TrimSpace(string(content)), "[") {
+			if err := json.Unmarshal(content, &values); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+		} else {
+			var request struct {
+				ISBN  string   `json:"isbn"`
+				ISBNs []string `json:"isbns"`
+			}
+			if err := json.Unmarshal(content, &request); err != nil {
+				return nil, fmt.Errorf("invalid JSON body: %w", err)
+			}
+			values = append(request.ISBNs, request.ISBN)
+		}
+
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		r.Body = io.NopCloser(bytes.NewReader(content))
+		if err := r.ParseMultipartForm(IngestMaximumBytes); err != nil && err != http.ErrNotMultipart {
+			return nil, fmt.Errorf("invalid form data: %w", err)
+		}
+		values = append(r.PostForm["isbn"], r.PostForm["isbns"]...)
+		if file, _, err := r.FormFile("file"); err == nil {
+			defer file.Close()
+			fileContent, err := io.ReadAll(file)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, string(fileContent))
+		}
+
+		// Some apps send plain text but call it a form
+		if len(values) == 0 && mediaType != "multipart/form-data" {
+			values = append(values, string(content))
+		}
+
+	default:
+		values = append(values, string(content))
+	}
+
+	// Scanner apps often include hyphens, which the ISBN checks don't allow
+	text := strings.ReplaceAll(strings.Join(values, "\n"), "-", "")
+	isbns, err := ParseISBNs(text)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each ISBN only needs looking up once
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, isbn := range isbns {
+		if !seen[isbn] {
+			seen[isbn] = true
+			unique = append(unique, isbn)
+		}
+	}
+	return unique, nil
+}
+
+// findBookIndex returns the position of the book with the given ISBN, or -1
+func findBookIndex(books []Book, isbn string) int {
+	for i := range books {
+		if books[i].ISBN == isbn {
+			return i
+		}
+	}
+	return -1
+}
+
+// readApiJson decodes a JSON request body, writing an error response if it can't
+// Unknown fields are rejected so that typing mistakes aren't silently ignored
+func readApiJson(w http.ResponseWriter, r *http.Request, value any) bool {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(value); err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid JSON body: %s", err.Error())
+		return false
+	}
+	return true
+}
+
+// writeApiJson writes a value as a JSON response
+func writeApiJson(w http.ResponseWriter, status int, value any) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, `{"error":"error encoding response"}`, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(append(content, '\n'))
+}
+
+// writeApiError writes a JSON error response
+func writeApiError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeApiJson(w, status, ApiError{Error: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+)
+
+// LoginHandler shows the sign in page
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.AuthEnabled() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	s.renderLogin(w, r, http.StatusOK, "", r.URL.Query().Get("next"))
+}
+
+// LoginPostHandler signs a user in, sending them on to where they were going
+func (s *Server) LoginPostHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.AuthEnabled() {
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// Parse the form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Check the password
+	name := r.FormValue("name")
+	next := getSafeRedirect(r.FormValue("next"))
+	user, ok := s.Authenticate(name, r.FormValue("password"))
+	if !ok {
+		s.renderLogin(w, r, http.StatusUnauthorized, "The name or password is incorrect.", next)
+		return
+	}
+
+	// Remember them
+	if err := s.CookieHandler.SetCookie(w, SessionCookie, s.getSession(user), SessionMaxAge); err != nil {
+		http.Error(w, "Error signing in: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// LogoutHandler signs the user out
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	s.CookieHandler.DeleteCookie(w, SessionCookie)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// renderLogin shows the sign in page with an optional message
+func (s *Server) renderLogin(w http.ResponseWriter, r *http.Request, status int, message string, next string) {
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Title:     "Sign In",
+		Content:   getSafeRedirect(next),
+		Message:   template.HTML(template.HTMLEscapeString(message)),
+		SigningIn: true,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	w.WriteHeader(status)
+	if err := templates.Render(w, "login", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// AuthorsHandler shows the authors known by more than one name, and the names that probably should be
+func (s *Server) AuthorsHandler(w http.ResponseWriter, r *http.Request) {
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.BooksLock.RLock()
+	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
+
+	data := TemplateData{
+		Title:    "Authors",
+		Filename: s.getDisplayFilename(),
+		Content: map[string]interface{}{
+			"Suggestions": SuggestAuthorMerges(books),
+			"Authorities": KnownAuthors.Authorities(),
+			"Merged":      r.URL.Query().Get("merged"),
+		},
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "authors", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuthorsMergeHandler makes the chosen names into one author, and changes their books to match
+func (s *Server) AuthorsMergeHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the form data
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	names := r.Form["variant"]
+	if len(names) < 2 {
+		http.Error(w, "At least two names are needed to merge", http.StatusBadRequest)
+		return
+	}
+
+	// Load the books from the JSON file
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books := LoadFile(s.Filename)
+
+	// Change and save the books, then remember the names
+	// The names are only remembered if the books were saved, so the two files agree
+	// Any error once the books are being changed is from saving rather than from the form
+	changed, status := 0, http.StatusBadRequest
+	_, err := KnownAuthors.Merge(r.FormValue("name"), r.FormValue("sort"), names, func(authority AuthorAuthority) error {
+		status = http.StatusInternalServerError
+		changed = MergeAuthorsInBooks(books, authority)
+		if changed == 0 {
+			return nil
+		}
+		return SaveBooks(s.Filename, books, s.Webhooks)
+	})
+	if err != nil {
+		http.Error(w, "Error merging authors: "+err.Error(), status)
+		return
+	}
+
+	// Redirect back to the authors page
+	http.Redirect(w, r, fmt.Sprintf("/authors?merged=%d", changed), http.StatusSeeOther)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// JobsHandler shows the background lookup jobs
+func (s *Server) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Title:     "Jobs",
+		Filename:  s.getDisplayFilename(),
+		Content:   s.Jobs.List(),
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "jobs", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// JobHandler shows the progress of a single background lookup job
+func (s *Server) JobHandler(w http.ResponseWriter, r *http.Request) {
+	job, _, found := s.getJob(r)
+	if !found {
+		s.NotFoundHandler(w, r)
+		return
+	}
+
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Title:     fmt.Sprintf("Job %d", job.ID),
+		Filename:  s.getDisplayFilename(),
+		Content:   job,
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "job", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// JobEventsHandler streams a job's progress as server-sent events until it is done
+func (s *Server) JobEventsHandler(w http.ResponseWriter, r *http.Request) {
+	job, changed, found := s.getJob(r)
+	if !found {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	for {
+		// Send the latest progress
+		content, err := json.Marshal(job)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "data: %s\n\n", content)
+		flusher.Flush()
+		if job.IsDone() {
+			return
+		}
+
+		// Wait for it to change (or the browser to go away)
+		select {
+		case <-changed:
+			// A job can be pruned while it is being watched, so say it is gone rather than waiting forever
+			job, changed, found = s.Jobs.Get(job.ID)
+			if !found {
+				fmt.Fprint(w, "event: gone\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// BulkAddHandler queues a list of ISBNs from the add page for looking up
+func (s *Server) BulkAddHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ISBNs, which can be typed in or uploaded
+	r.Body = http.MaxBytesReader(w, r.Body, IngestMaximumBytes)
+	isbns, err := readIngestISBNs(r)
+	if err != nil {
+		http.Error(w, "Error reading ISBNs: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(isbns) == 0 {
+		http.Error(w, "At least one ISBN is required", http.StatusBadRequest)
+		return
+	}
+
+	// Failed searches are saved, as they are for the ISBNs file
+	job, err := s.Jobs.Add(isbns, "Bulk Add", true)
+	if err != nil {
+		http.Error(w, "Error queueing ISBNs: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%d", job), http.StatusSeeOther)
+}
+
+// getJob returns the job whose ID is in the URL
+func (s *Server) getJob(r *http.Request) (JobSnapshot, <-chan struct{}, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return JobSnapshot{}, nil, false
+	}
+	return s.Jobs.Get(id)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+)
+
+// WebhooksHandler shows the recent webhook deliveries
+func (s *Server) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := TemplateData{
+		Title:    "Webhooks",
+		Filename: s.getDisplayFilename(),
+		Content: map[string]interface{}{
+			"Webhooks":   s.Config.Webhooks,
+			"Deliveries": s.Webhooks.Deliveries(),
+		},
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "webhooks", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
@@ -20,64 +23,32 @@ func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Load the books from the JSON file
-	books := LoadFile(s.Filename)
+	// A filter or sort in the URL is only used for this page, and doesn't change the preferences
+	preferences := s.getRequestPreferences(r)
 
-	// Default title and filter
-	title := "All Books"
-	var filter BookFilter
-
-	// Get the filter from cookie
-	filterName, err := s.CookieHandler.GetCookie(r, "mfw-filter")
-	if err == nil {
-		// Apply the appropriate filter
-		switch strings.ToLower(filterName) {
-		case "all":
-			filter = GetPopulatedAllBooksFilter(books)
-		case "reading":
-			filter = GetPopulatedReadingFilter(books)
-		case "next":
-			filter = GetPopulatedNextFilter(books)
-		case "done":
-			filter = GetPopulatedDoneFilter(books)
-		case "other":
-			filter = GetPopulatedOtherFilter(books)
-		}
-		books = filter.Books
-		title = filter.Name
-	}
-
-	// Get the sort field and direction from cookies
-	sortField, err := s.CookieHandler.GetCookie(r, "mfw-sort-details")
-	if err == nil {
-		sortDirection, _ := s.CookieHandler.GetCookie(r, "mfw-sort-direction")
-		descending := sortDirection == "desc"
-
-		// Apply the appropriate sort based on the field
-		switch strings.ToLower(sortField) {
-		case "isbn":
-			SortBooksByISBN(books, descending)
-		case "status":
-			SortBooksByStatus(books, descending)
-		case "title":
-			SortBooksByTitle(books, descending)
-		case "author":
-			SortBooksByAuthor(books, descending)
-		case "series":
-			SortBooksBySeries(books, descending)
-		case "rating":
-			SortBooksByRating(books, descending)
-		case "genre":
-			SortBooksByGenre(books, descending)
-		}
-	}
+	// Load the books with the chosen filter, sort, and search, and show the requested page of them
+	selection := s.getSelectedBooks(r)
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	paging := GetPaging(len(selection.Books), preferences.PageSize, page)
 
 	// Create the template data
 	data := TemplateData{
-		Title:     title,
-		Filename:  s.Filename,
-		Content:   books,
-		SortField: sortField,
+		Title:         selection.Title,
+		Filename:      s.getDisplayFilename(),
+		Content:       selection.Books[paging.First:paging.Last],
+		Preferences:   &preferences,
+		Paging:        &paging,
+		FilterKey:     selection.FilterKey,
+		SortLevels:    selection.SortLevels,
+		Query:         selection.Query,
+		SearchError:   selection.SearchError,
+		Exporters:     Exporters,
+		ExportColumns: ExportColumns,
+		User:          currentUser(r),
+		Filters:       s.Config.Filters,
+		CsrfToken:     csrfToken(r),
+		CspNonce:      cspNonce(r),
+		BorrowLink:    s.getBorrowLink(),
 	}
 
 	// Render the template
@@ -87,48 +58,199 @@ func (s *Server) HomeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// SortHandler handles sorting requests
-func (s *Server) SortHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the sort field from the URL
+// BookSelection is the result of applying a filter and sort to the books
+type BookSelection struct {
+	Books      []Book
+	Title      string
+	FilterKey  string
+	SortLevels []SortLevel
+	Query      string
+
+	// SearchError explains why the search couldn't be understood
+	SearchError string
+}
+
+// getRequestPreferences returns the browser's preferences, overridden by any
+// filter (?filter=), sort (?sort=), and direction (?dir=) in the URL
+// Sorts and directions can be comma-separated or repeated for sorts with more than one level
+func (s *Server) getRequestPreferences(r *http.Request) Preferences {
+	preferences := s.getPreferences(r)
+	query := r.URL.Query()
+	if query.Has("filter") {
+		preferences.Filter = query.Get("filter")
+	}
+	if query.Has("sort") {
+		preferences.SortField = strings.Join(query["sort"], ",")
+		preferences.SortDirection = strings.ToLower(strings.Join(query["dir"], ","))
+	}
+	return CleanPreferences(preferences, s.Config.Filters)
+}
+
+// getSelectedBooks loads the books and applies the filter, sort, and search
+// from the URL, falling back on the browser's preferences
+func (s *Server) getSelectedBooks(r *http.Request) BookSelection {
+	// Load the books from the JSON file
+	selection := BookSelection{
+		Books: s.LoadBooks(),
+		Title: "All Books",
+		Query: strings.TrimSpace(r.URL.Query().Get("q")),
+	}
+	preferences := s.getRequestPreferences(r)
+
+	// Apply the appropriate filter (the first one if none has been chosen)
+	if preferences.Filter == "" && len(s.Config.Filters) > 0 {
+		preferences.Filter = s.Config.Filters[0].Key
+	}
+	if filter, ok := s.Config.Filters.GetPopulatedFilter(preferences.Filter, selection.Books); ok {
+		selection.Books = filter.Books
+		selection.Title = filter.Name
+		selection.FilterKey = strings.ToLower(preferences.Filter)
+	}
+
+	// Apply the search
+	// A search that can't be understood finds nothing, and says why
+	if selection.Query != "" {
+		found, err := SearchBooks(selection.Books, selection.Query)
+		if err != nil {
+			selection.SearchError = err.Error()
+		}
+		selection.Books = found
+	}
+
+	// Apply the sort, which has already had anything unknown removed
+	selection.SortLevels, _ = ParseSortLevels(preferences.SortField, preferences.SortDirection)
+	SortBooks(selection.Books, selection.SortLevels)
+
+	return selection
+}
+
+// ExportHandler downloads the books in the current filter and sort as a file
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the format and columns from the query string
+	// Columns may be comma-separated, repeated (eg from checkboxes), or both
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	exporter, err := GetExporter(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := ParseExportColumns(strings.Join(query["columns"], ","))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Load the books with the chosen filter and sort, limited to any requested ISBNs
+	selection := s.getSelectedBooks(r)
+	books, title := selection.Books, selection.Title
+	if isbns := query.Get("isbns"); isbns != "" {
+		books = SelectBooksByISBN(books, splitMultiValue(isbns, ", "))
+		title = "Selected"
+	}
+
+	// Export in full first, so any error can still be reported
+	var content bytes.Buffer
+	if err := exporter.Write(&content, books, ExportOptions{Columns: columns, Config: s.Config}); err != nil {
+		http.Error(w, "Error exporting books: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Send the file as a download named after the filter
+	filename := fmt.Sprintf("books-%s.%s", strings.ReplaceAll(strings.ToLower(title), " ", "-"), exporter.Extension)
+	w.Header().Set("Content-Type", exporter.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Write(content.Bytes())
+}
+
+// OpdsHandler serves the OPDS catalog feeds, as Atom (v1) or JSON (v2)
+func (s *Server) OpdsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the version and feed from the URL
 	vars := mux.Vars(r)
-	newSortField := vars["field"]
-
-	// Get the current sort field and direction from cookies
-	currentSortField, _ := s.CookieHandler.GetCookie(r, "mfw-sort-details")
-	currentSortDirection, _ := s.CookieHandler.GetCookie(r, "mfw-sort-direction")
-
-	// Determine the new sort direction
-	var newSortDirection string
-	if newSortField == currentSortField {
-		// Invert the current direction
-		if currentSortDirection == "asc" {
-			newSortDirection = "desc"
-		} else {
-			newSortDirection = "asc"
+	version := vars["version"]
+	feed, err := GetOpdsFeed(vars["feed"], r.URL.Query(), s.LoadBooks(), s.Config.Filters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// Write the feed in full first, so any error can still be reported
+	var content bytes.Buffer
+	contentType := Opds2Type
+	if version == "v1" {
+		contentType = OpdsAcquisitionType
+		if feed.IsNavigation() {
+			contentType = OpdsNavigationType
 		}
+		err = WriteOpdsAtom(&content, feed, GetBaseURL(r))
 	} else {
-		// Use default direction for the new field
-		if newSortField == "rating" {
-			newSortDirection = "desc"
-		} else {
-			newSortDirection = "asc"
-		}
+		err = WriteOpds2(&content, feed, GetBaseURL(r))
 	}
-
-	// Set both cookies
-	err := s.CookieHandler.SetCookie(w, "mfw-sort-details", newSortField, 86400) // 24 hours
 	if err != nil {
-		http.Error(w, "Error setting sort field cookie: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Error writing feed: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	err = s.CookieHandler.SetCookie(w, "mfw-sort-direction", newSortDirection, 86400) // 24 hours
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(content.Bytes())
+}
+
+// FeedHandler serves the Atom feeds of recently added and finished books
+func (s *Server) FeedHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the feed from the URL
+	vars := mux.Vars(r)
+	feed, err := GetBookFeed(vars["feed"], r.URL.Query(), s.LoadBooks())
 	if err != nil {
-		http.Error(w, "Error setting sort direction cookie: "+err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	// Redirect back to the home page
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	// Write the feed in full first, so any error can still be reported
+	var content bytes.Buffer
+	if err := WriteBookFeed(&content, feed, GetBaseURL(r)); err != nil {
+		http.Error(w, "Error writing feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write(content.Bytes())
+}
+
+// StatusesCssHandler serves the stylesheet that colours the status icons
+func (s *Server) StatusesCssHandler(w http.ResponseWriter, r *http.Request) {
+	var content bytes.Buffer
+	if err := WriteStatusesCss(&content, KnownStatuses); err != nil {
+		http.Error(w, "Error writing stylesheet: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Write(content.Bytes())
+}
+
+// SortHandler handles sorting requests
+func (s *Server) SortHandler(w http.ResponseWriter, r *http.Request) {
+	// The page being sorted passes its filter and sort in the URL, as they may not be the preferences
+	preferences := s.getRequestPreferences(r)
+	levels, _ := ParseSortLevels(preferences.SortField, preferences.SortDirection)
+
+	// Determine the new sort from the current one (the sort builder gives the whole sort instead)
+	// Adding (?add=1) makes the field another level of the sort rather than replacing it
+	if newSortField, ok := mux.Vars(r)["field"]; ok {
+		levels = ChooseSortField(levels, newSortField, r.URL.Query().Get("add") == "1")
+	}
+	preferences.SortField, preferences.SortDirection = FormatSortLevels(levels)
+
+	// Keep the new sort
+	if err := s.savePreferences(w, r, preferences); err != nil {
+		http.Error(w, "Error saving sort: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect to the sorted page, so it can be bookmarked
+	http.Redirect(w, r, getHomeURL(preferences.Filter, levels, r.URL.Query().Get("q"), 0), http.StatusSeeOther)
 }
 
 // FilterHandler handles filter requests
@@ -137,10 +259,33 @@ func (s *Server) FilterHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	filterName := vars["filter"]
 
-	// Set the filter cookie
-	err := s.CookieHandler.SetCookie(w, "mfw-filter", filterName, 86400) // 24 hours
-	if err != nil {
-		http.Error(w, "Error setting filter cookie: "+err.Error(), http.StatusInternalServerError)
+	// Keep the new filter, along with the sort of the page it was chosen on
+	preferences := s.getRequestPreferences(r)
+	preferences.Filter = filterName
+	if err := s.savePreferences(w, r, preferences); err != nil {
+		http.Error(w, "Error saving filter: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Redirect to the filtered page, so it can be bookmarked
+	levels, _ := ParseSortLevels(preferences.SortField, preferences.SortDirection)
+	http.Redirect(w, r, getHomeURL(filterName, levels, r.URL.Query().Get("q"), 0), http.StatusSeeOther)
+}
+
+// PreferencesHandler changes which columns are shown on the home page, and how many books per page
+func (s *Server) PreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the chosen columns and page size from the form
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Error parsing form: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	preferences := s.getPreferences(r)
+	preferences.Columns = append([]string{}, r.Form["columns"]...)
+	preferences.PageSize, _ = strconv.Atoi(r.FormValue("pageSize"))
+
+	// Keep them
+	if err := s.savePreferences(w, r, preferences); err != nil {
+		http.Error(w, "Error saving preferences: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -161,7 +306,9 @@ func (s *Server) EditHandler(w http.ResponseWriter, r *http.Request) {
 	isbn := vars["isbn"]
 
 	// Load the books from the JSON file
+	s.BooksLock.RLock()
 	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
 
 	// Find the book with the matching ISBN
 	var book *Book
@@ -214,11 +361,15 @@ func (s *Server) EditHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Create the template data
 	data := TemplateData{
-		Title:    "Edit Book",
-		Filename: s.Filename,
-		Content:  book,
-		Series:   series,
-		Genres:   genres,
+		Title:     "Edit Book",
+		Filename:  s.getDisplayFilename(),
+		Content:   book,
+		Series:    series,
+		Genres:    genres,
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
 	}
 
 	// Render the template
@@ -228,6 +379,86 @@ func (s *Server) EditHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CiteHandler shows a book in each of the citation formats
+func (s *Server) CiteHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ISBN from the URL
+	vars := mux.Vars(r)
+	isbn := vars["isbn"]
+
+	// Find the book with the matching ISBN
+	book, found := findBookByISBN(s.LoadBooks(), isbn)
+	if !found {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create the template data
+	data := TemplateData{
+		Title:    "Cite Book",
+		Filename: s.getDisplayFilename(),
+		Content: struct {
+			Book      Book
+			Citations []Citation
+		}{book, GetCitations(book)},
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "cite", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// ViewHandler shows a book read-only, for those who can't edit it
+func (s *Server) ViewHandler(w http.ResponseWriter, r *http.Request) {
+	// Get the ISBN from the URL
+	vars := mux.Vars(r)
+	isbn := vars["isbn"]
+
+	// Find the book with the matching ISBN
+	book, found := findBookByISBN(s.LoadBooks(), isbn)
+	if !found {
+		http.Error(w, "Book not found", http.StatusNotFound)
+		return
+	}
+
+	// Create a new template manager
+	templates, err := NewTemplates()
+	if err != nil {
+		http.Error(w, "Error loading templates: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Create the template data
+	data := TemplateData{
+		Title:      book.Title,
+		Filename:   s.getDisplayFilename(),
+		Content:    &book,
+		User:       currentUser(r),
+		Filters:    s.Config.Filters,
+		CsrfToken:  csrfToken(r),
+		CspNonce:   cspNonce(r),
+		BorrowLink: s.getBorrowLink(),
+	}
+
+	// Render the template
+	if err := templates.Render(w, "book", data); err != nil {
+		http.Error(w, "Error rendering template: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
 // SaveHandler handles saving book edits
 func (s *Server) SaveHandler(w http.ResponseWriter, r *http.Request) {
 	// Get the ISBN from the URL
@@ -240,15 +471,9 @@ func (s *Server) SaveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate required fields
-	title := r.FormValue("title")
-	authorSort := r.FormValue("authorSort")
-	if title == "" || authorSort == "" {
-		http.Error(w, "Title and Author Sort are required", http.StatusBadRequest)
-		return
-	}
-
 	// Load the books from the JSON file
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
 	books := LoadFile(s.Filename)
 
 	// Find the book with the matching ISBN
@@ -273,37 +498,52 @@ func (s *Server) SaveHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update only the allowed fields
-	books[bookIndex].Title = strings.TrimSpace(title)
-	books[bookIndex].AuthorSort = splitAndTrim(authorSort)
-	books[bookIndex].Genre[0] = cleanGenre(r.FormValue("genre1"))
-	books[bookIndex].Genre[1] = cleanGenre(r.FormValue("genre2"))
-	books[bookIndex].Series = strings.TrimSpace(r.FormValue("series"))
-	books[bookIndex].Sequence = strings.TrimSpace(r.FormValue("sequence"))
-	books[bookIndex].Status = strings.TrimSpace(r.FormValue("status"))
-	books[bookIndex].Notes = strings.TrimSpace(r.FormValue("notes"))
-	if len(books[bookIndex].Status) > 0 {
-		books[bookIndex].StatusIcon = string(books[bookIndex].Status[0]) // First character of status
-	}
-
-	// Parse rating
-	ratingStr := r.FormValue("rating")
-	if ratingStr != "" {
-		rating, err := strconv.Atoi(ratingStr)
-		if err != nil || rating < 0 || rating > 5 {
+	title := r.FormValue("title")
+	sortTitle := r.FormValue("sortTitle")
+	authorSort := splitAndTrim(r.FormValue("authorSort"))
+	genres := []string{r.FormValue("genre1"), r.FormValue("genre2")}
+	series := r.FormValue("series")
+	sequence := r.FormValue("sequence")
+	status := r.FormValue("status")
+	notes := r.FormValue("notes")
+	rating := 0
+	if ratingStr := r.FormValue("rating"); ratingStr != "" {
+		var err error
+		if rating, err = strconv.Atoi(ratingStr); err != nil {
 			http.Error(w, "Rating must be a whole number between 0 and 5", http.StatusBadRequest)
 			return
 		}
-		books[bookIndex].Rating = rating
-	} else {
-		books[bookIndex].Rating = 0
+	}
+	changes := BookChanges{
+		Title:      &title,
+		SortTitle:  &sortTitle,
+		AuthorSort: &authorSort,
+		Genre:      &genres,
+		Series:     &series,
+		Sequence:   &sequence,
+		Status:     &status,
+		Rating:     &rating,
+		Notes:      &notes,
+	}
+	previousAuthorSort := slices.Clone(books[bookIndex].AuthorSort)
+	if err := ApplyBookChanges(&books[bookIndex], changes); err != nil {
+		http.Error(w, "Invalid book: "+err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Save the updated books
-	if err := SaveFile(s.Filename, books); err != nil {
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
 		http.Error(w, "Error saving file: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Remember any author sort corrections for future imports
+	if !slices.Equal(previousAuthorSort, books[bookIndex].AuthorSort) {
+		if err := KnownAuthors.Learn(books[bookIndex].Authors, books[bookIndex].AuthorSort); err != nil {
+			log.Printf("Could not save author sorts: %v", err)
+		}
+	}
+
 	// Redirect back to the home page
 	http.Redirect(w, r, "/#b_"+isbn, http.StatusSeeOther)
 }
@@ -353,8 +593,12 @@ func (s *Server) AddHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := TemplateData{
-		Title:    "Add Book",
-		Filename: s.Filename,
+		Title:     "Add Book",
+		Filename:  s.getDisplayFilename(),
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
 	}
 
 	// Render the template
@@ -396,9 +640,13 @@ func (s *Server) MessageHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := TemplateData{
-		Title:    title,
-		Filename: s.Filename,
-		Message:  template.HTML(formattedMessage),
+		Title:     title,
+		Filename:  s.getDisplayFilename(),
+		Message:   template.HTML(formattedMessage),
+		User:      currentUser(r),
+		Filters:   s.Config.Filters,
+		CsrfToken: csrfToken(r),
+		CspNonce:  cspNonce(r),
 	}
 
 	// Render the template
@@ -423,30 +671,21 @@ func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Load the books from the JSON file
+	// Check if we already have it
+	s.BooksLock.RLock()
 	books := LoadFile(s.Filename)
-
-	// Look up the book
-	book, found, err := lookupBook(isbn, books, false) // Use double-hit mode for better data
-	if found {
+	s.BooksLock.RUnlock()
+	if _, found := findBookByISBN(books, isbn); found {
 		// Book already exists, show message
 		http.Redirect(w, r, fmt.Sprintf("/message/exists?isbn=%s", isbn), http.StatusSeeOther)
 		return
 	}
 
+	// Look it up in the background, showing the progress (failed searches aren't saved)
+	job, err := s.Jobs.Add([]string{isbn}, "Search", false)
 	if err != nil {
-		// Book not found, show message
-		http.Redirect(w, r, fmt.Sprintf("/message/not-found?isbn=%s", isbn), http.StatusSeeOther)
+		http.Error(w, "Error queueing search: "+err.Error(), http.StatusServiceUnavailable)
 		return
 	}
-
-	// Add the new book to the database
-	books = append(books, book)
-	if err := SaveFile(s.Filename, books); err != nil {
-		http.Error(w, "Error saving file: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Redirect to the edit page for the new book
-	http.Redirect(w, r, fmt.Sprintf("/books/edit/%s", isbn), http.StatusSeeOther)
+	http.Redirect(w, r, fmt.Sprintf("/jobs/%d", job), http.StatusSeeOther)
 }
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TabularMapping maps (lowercased) column headers to Book fields
+type TabularMapping map[string]string
+
+// TabularRow is a single mapped row from a CSV/TSV file
+type TabularRow struct {
+	Line int
+	Book Book
+	Err  error
+}
+
+// TabularFields are the Book fields a column can be mapped to
+// The names match the JSON names used in the books file
+var TabularFields = []string{
+	"isbn", "title", "sortTitle", "authors", "authorSort", "genre", "publishedDate", "publisher",
+	"pageCount", "language", "description", "series", "sequence", "status", "rating", "notes",
+}
+
+// TabularPresets are the built-in column mappings, selected by name
+var TabularPresets = map[string]TabularMapping{
+	// Matches headers named like the fields themselves, plus common variations
+	"generic": {
+		"isbn":           "isbn",
+		"isbn13":         "isbn",
+		"isbn10":         "isbn",
+		"title":          "title",
+		"sorttitle":      "sortTitle",
+		"sort title":     "sortTitle",
+		"author":         "authors",
+		"authors":        "authors",
+		"authorsort":     "authorSort",
+		"author sort":    "authorSort",
+		"genre":          "genre",
+		"genres":         "genre",
+		"categories":     "genre",
+		"published":      "publishedDate",
+		"publisheddate":  "publishedDate",
+		"published date": "publishedDate",
+		"year":           "publishedDate",
+		"publisher":      "publisher",
+		"pages":          "pageCount",
+		"pagecount":      "pageCount",
+		"page count":     "pageCount",
+		"language":       "language",
+		"description":    "description",
+		"series":         "series",
+		"sequence":       "sequence",
+		"series number":  "sequence",
+		"status":         "status",
+		"rating":         "rating",
+		"notes":          "notes",
+	},
+
+	// LibraryThing's tab-delimited export
+	"librarything": {
+		"isbn":             "isbn",
+		"title":            "title",
+		"primary author":   "authorSort",
+		"secondary author": "authorSort",
+		"tags":             "genre",
+		"date":             "publishedDate",
+		"publication":      "publisher",
+		"page count":       "pageCount",
+		"languages":        "language",
+		"series":           "series",
+		"collections":      "status",
+		"rating":           "rating",
+		"private comment":  "notes",
+		"comment":          "notes",
+	},
+
+	// Goodreads' library export
+	"goodreads": {
+		"isbn13":                    "isbn",
+		"isbn":                      "isbn",
+		"title":                     "title",
+		"author":                    "authors",
+		"additional authors":        "authors",
+		"author l-f":                "authorSort",
+		"publisher":                 "publisher",
+		"number of pages":           "pageCount",
+		"year published":            "publishedDate",
+		"original publication year": "publishedDate",
+		"exclusive shelf":           "status",
+		"my rating":                 "rating",
+		"private notes":             "notes",
+	},
+}
+
+// statusWords maps words commonly used for reading status to our status letters
+var statusWords = map[string]string{
+	"unread":            "U",
+	"to read":           "U",
+	"to-read":           "U",
+	"current":           "C",
+	"reading":           "C",
+	"currently reading": "C",
+	"currently-reading": "C",
+	"next":              "N",
+	"next up":           "N",
+	"read":              "R",
+	"finished":          "R",
+	"abandoned":         "A",
+	"did not finish":    "A",
+	"dnf":               "A",
+	"unwanted":          "X",
+	"lent":              "L",
+	"lent out":          "L",
+	"gone":              "G",
+}
+
+// languageNames maps language names to the codes Google Books uses
+var languageNames = map[string]string{
+	"english": "en", "french": "fr", "german": "de", "spanish": "es", "italian": "it",
+	"dutch": "nl", "portuguese": "pt", "swedish": "sv", "danish": "da", "norwegian": "no",
+	"finnish": "fi", "polish": "pl", "russian": "ru", "japanese": "ja", "chinese": "zh",
+	"welsh": "cy", "irish": "ga", "latin": "la", "greek": "el",
+}
+
+// seriesNumberPattern matches a trailing series number like "(1)", "(1-3)", or "#1"
+var seriesNumberPattern = regexp.MustCompile(`\s*(?:\(([^()]+)\)|,?\s*#\s*(\S+))\s*$`)
+
+// GetTabularMapping returns the mapping for a preset name, a mapping file,
+// or an inline list of 'Column=field' pairs separated by commas
+// An empty value gives the generic preset
+func GetTabularMapping(value string) TabularMapping {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return TabularPresets["generic"]
+	}
+	if preset, ok := TabularPresets[strings.ToLower(value)]; ok {
+		return preset
+	}
+
+	// A mapping file has one 'Column = field' per line, with # for comments
+	pairs := strings.Split(value, ",")
+	exists, f, err := CheckFileExists(value)
+	check(err)
+	if exists {
+		f.Close()
+		pairs = strings.Split(readFileNormalisedToLF(value), "\n")
+	}
+
+	mapping := TabularMapping{}
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" || strings.HasPrefix(pair, "#") {
+			continue
+		}
+		column, field, ok := strings.Cut(pair, "=")
+		if !ok {
+			check(fmt.Errorf("invalid column mapping (expected 'Column=field'): %s", pair))
+		}
+		name := getTabularField(field)
+		if name == "" {
+			check(fmt.Errorf("unknown book field '%s' (expected one of %s)", strings.TrimSpace(field), strings.Join(TabularFields, ", ")))
+		}
+		mapping[strings.ToLower(strings.TrimSpace(column))] = name
+	}
+	if len(mapping) == 0 {
+		check(fmt.Errorf("no column mappings found in: %s", value))
+	}
+	return mapping
+}
+
+// LoadTabular reads a CSV or TSV file, returning the headers and the data rows
+// Tabs are used if the file extension says so or the header line has more tabs than commas
+func LoadTabular(filename string) ([]string, [][]string) {
+	exists, f, err := CheckFileExists(filename)
+	check(err)
+	if !exists {
+		check(fmt.Errorf("file not found: %s", filename))
+	}
+	f.Close()
+
+	content, err := os.ReadFile(filename)
+	check(err)
+	content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+
+	// Work out the delimiter
+	firstLine, _, _ := bytes.Cut(content, []byte("\n"))
+	ext := strings.ToLower(filepath.Ext(filename))
+	delimiter := ','
+	if ext == ".tsv" || ext == ".tab" || bytes.Count(firstLine, []byte("\t")) > bytes.Count(firstLine, []byte(",")) {
+		delimiter = '\t'
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comma = delimiter
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		check(fmt.Errorf("error reading %s: %w", filename, err))
+	}
+	if len(records) == 0 {
+		check(fmt.Errorf("no header row found in: %s", filename))
+	}
+	return records[0], records[1:]
+}
+
+// MapTabularRows converts the data rows into books using the column mapping
+// The title and author sort are tidied in the same way as books fetched by ISBN
+func MapTabularRows(headers []string, records [][]string, mapping TabularMapping) []TabularRow {
+	// Work out which field each column feeds
+	fields := make([]string, len(headers))
+	mapped := 0
+	for i, header := range headers {
+		if field, ok := mapping[strings.ToLower(strings.TrimSpace(header))]; ok {
+			fields[i] = field
+			mapped++
+		}
+	}
+	if mapped == 0 {
+		check(fmt.Errorf("none of the columns match the mapping (columns are: %s)", strings.Join(headers, ", ")))
+	}
+
+	rows := []TabularRow{}
+	for r, record := range records {
+		row := TabularRow{Line: r + 2} // 1-based, after the header
+		for i, value := range record {
+			if i < len(fields) && fields[i] != "" {
+				setTabularField(&row.Book, fields[i], strings.TrimSpace(value))
+			}
+		}
+		row.Err = finishTabularBook(&row.Book)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// PreviewTabular shows the first count mapped rows without importing anything
+func PreviewTabular(rows []TabularRow, count int) {
+	grid := NewGrid([]string{"LINE", "ISBN", "TITLE", "AUTHOR SORT", "SERIES", "STATUS", "RATING", "GENRE", "ERROR"})
+	for i, row := range rows {
+		if i >= count {
+			break
+		}
+		errorText := ""
+		if row.Err != nil {
+			errorText = row.Err.Error()
+		}
+		grid.AddRow(
+			fmt.Sprintf("%d", row.Line),
+			row.Book.ISBN,
+			row.Book.Title,
+			row.Book.GetAuthorSortDisplay(),
+			row.Book.GetSeriesSort(),
+			row.Book.StatusIcon,
+			fmt.Sprintf("%d", row.Book.Rating),
+			joinWithAmpersand(row.Book.Genre),
+			errorText,
+		)
+	}
+	fmt.Println(grid)
+	fmt.Printf("Previewed %d of %d row(s). Nothing has been imported.\n", min(count, len(rows)), len(rows))
+	fmt.Println()
+}
+
+// ImportTabular adds the mapped rows to the books, skipping any ISBNs we already have
+// It returns the books, and how many were added
+func ImportTabular(rows []TabularRow, books []Book) ([]Book, int) {
+	grid := NewGrid([]string{"LINE", "ISBN", "NEW?", "TITLE", "AUTHORS", "ERROR"})
+
+	// Track counts
+	var newCount, matchedCount, errorCount int
+	originalCount := len(books)
+
+	for _, row := range rows {
+		line := fmt.Sprintf("%d", row.Line)
+		if row.Err != nil {
+			grid.AddRow(line, row.Book.ISBN, "Error", row.Book.Title, "", row.Err.Error())
+			errorCount++
+			continue
+		}
+		if existing, found := findBookByISBN(books, row.Book.ISBN); found {
+			grid.AddRow(line, row.Book.ISBN, "-", existing.Title, existing.GetAuthorSortDisplay(), existing.ExceptionReason)
+			matchedCount++
+			continue
+		}
+		grid.AddRow(line, row.Book.ISBN, "Yes", row.Book.Title, row.Book.GetAuthorSortDisplay(), "")
+		books = append(books, row.Book)
+		newCount++
+	}
+
+	// Print the grid
+	fmt.Println(grid)
+	fmt.Println()
+
+	// Print summary
+	fmt.Printf("Started with %d books in the database.\n", originalCount)
+	fmt.Printf("%d added, %d matched, and %d skipped with errors.\n", newCount, matchedCount, errorCount)
+	fmt.Printf("Ended with %d books in the database.\n", len(books))
+	fmt.Println()
+
+	return books, newCount
+}
+
+// getTabularField returns the properly-cased field name, or an empty string if unknown
+func getTabularField(name string) string {
+	name = strings.TrimSpace(name)
+	for _, field := range TabularFields {
+		if strings.EqualFold(field, name) {
+			return field
+		}
+	}
+	return ""
+}
+
+// setTabularField sets a book field from a cell value
+// Multi-valued fields accumulate, notes and description are joined,
+// and for everything else the first non-empty value wins
+func setTabularField(book *Book, field string, value string) {
+	if value == "" {
+		return
+	}
+	switch field {
+	case "isbn":
+		if book.ISBN == "" {
+			book.ISBN = cleanISBN(value)
+		}
+	case "title":
+		if book.Title == "" {
+			book.Title = value
+		}
+	case "sortTitle":
+		if book.SortTitle == "" {
+			book.SortTitle = value
+		}
+	case "authors":
+		book.Authors = append(book.Authors, splitMultiValue(value, "|;&")...)
+	case "authorSort":
+		book.AuthorSort = append(book.AuthorSort, splitMultiValue(value, "|;&")...)
+	case "genre":
+		for _, genre := range splitMultiValue(value, "|;&,") {
+			book.Genre = append(book.Genre, cleanGenre(genre))
+		}
+	case "publishedDate":
+		if book.PublishedDate == "" {
+			book.PublishedDate = value
+		}
+	case "publisher":
+		if book.Publisher == "" {
+			// Drop any trailing details, eg 'Pan (1982), Paperback, 411 pages'
+			publisher, _, _ := strings.Cut(value, " (")
+			book.Publisher = strings.TrimSpace(publisher)
+		}
+	case "pageCount":
+		if book.PageCount == 0 {
+			book.PageCount = leadingNumber(value)
+		}
+	case "language":
+		if book.Language == "" {
+			book.Language = cleanLanguage(value)
+		}
+	case "description":
+		book.Description = joinParagraphs(book.Description, value)
+	case "series":
+		if book.Series == "" {
+			// Only the first series is kept, and any number becomes the sequence
+			series, _, _ := strings.Cut(value, ";")
+			series = strings.TrimSpace(series)
+			if match := seriesNumberPattern.FindStringSubmatchIndex(series); match != nil {
+				number := series[match[2]:match[3]]
+				if match[2] < 0 {
+					number = series[match[4]:match[5]]
+				}
+				if book.Sequence == "" {
+					book.Sequence = strings.TrimSpace(number)
+				}
+				series = strings.TrimSpace(series[:match[0]])
+			}
+			book.Series = series
+		}
+	case "sequence":
+		if book.Sequence == "" {
+			book.Sequence = value
+		}
+	case "status":
+		if book.StatusIcon == "" {
+			book.Status, book.StatusIcon = cleanStatus(value)
+		}
+	case "rating":
+		if book.Rating == 0 {
+			if rating, err := strconv.ParseFloat(value, 64); err == nil {
+				book.Rating = max(0, min(5, int(math.Round(rating))))
+			}
+		}
+	case "notes":
+		book.Notes = joinParagraphs(book.Notes, value)
+	}
+}
+
+// finishTabularBook fills in the derived fields and checks the book can be imported
+func finishTabularBook(book *Book) error {
+	book.ModifiedUtc = time.Now().UTC().Format(time.RFC3339)
+	book.AddedUtc = book.ModifiedUtc
+	if book.ISBN == "" {
+		return fmt.Errorf("no ISBN")
+	}
+	if book.Title == "" {
+		return fmt.Errorf("no title")
+	}
+
+	// Fill in whichever of the author fields is missing, using the names authors are known by
+	book.Authors = fixAuthorNames(book.Authors)
+	if len(book.AuthorSort) == 0 && len(book.Authors) > 0 {
+		book.AuthorSort = fixAuthorSorts(book.Authors)
+	}
+	if len(book.Authors) == 0 {
+		for _, authorSort := range book.AuthorSort {
+			book.Authors = append(book.Authors, unfixAuthorSort(authorSort))
+		}
+	}
+
+	// Books have exactly two genres
+	book.Genre = append(book.Genre, "", "")[:2]
+
+	// New books have the first status unless we're told otherwise
+	if book.StatusIcon == "" {
+		book.Status, book.StatusIcon = KnownStatuses.Initial().Full(), KnownStatuses.Initial().Code
+	}
+	return nil
+}
+
+// cleanISBN keeps only the digits (and any X check digit) of an ISBN
+// This handles forms like '[0330266560]', '978-0-330-26656-7', and '="9780330266567"'
+func cleanISBN(value string) string {
+	var sb strings.Builder
+	for _, c := range value {
+		if c >= '0' && c <= '9' {
+			sb.WriteRune(c)
+		} else if c == 'x' || c == 'X' {
+			sb.WriteRune('X')
+		}
+	}
+	return sb.String()
+}
+
+// cleanStatus converts a status letter, full status, label, or reading status word into our status
+// Lists (eg LibraryThing collections) use the first recognised entry
+func cleanStatus(value string) (string, string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if status, ok := KnownStatuses.FindByLabel(item); ok {
+			return status.Full(), status.Code
+		}
+		letter := strings.ToUpper(item)
+		if len(item) > 1 {
+			letter = statusWords[strings.ToLower(item)]
+			if letter == "" && strings.HasPrefix(item[1:], " - ") {
+				letter = strings.ToUpper(item[:1])
+			}
+		}
+		if status, ok := KnownStatuses.Get(letter); ok {
+			return status.Full(), status.Code
+		}
+	}
+	return "", ""
+}
+
+// cleanLanguage converts a language name to its two-letter code
+// Anything unrecognised (including codes) is kept as-is
+func cleanLanguage(value string) string {
+	first, _, _ := strings.Cut(value, ",")
+	first = strings.TrimSpace(first)
+	if code, ok := languageNames[strings.ToLower(first)]; ok {
+		return code
+	}
+	return first
+}
+
+// splitMultiValue splits a cell on any of the separator characters, dropping blanks
+func splitMultiValue(value string, separators string) []string {
+	result := []string{}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(separators, r) }) {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// unfixAuthorSort converts an author sort like 'May, Julian' back into 'Julian May'
+func unfixAuthorSort(authorSort string) string {
+	last, first, ok := strings.Cut(authorSort, ",")
+	if !ok {
+		return strings.TrimSpace(authorSort)
+	}
+	return strings.TrimSpace(strings.TrimSpace(first) + " " + strings.TrimSpace(last))
+}
+
+// leadingNumber returns the number at the start of a value like '411 pages', or zero
+func leadingNumber(value string) int {
+	end := 0
+	for end < len(value) && value[end] >= '0' && value[end] <= '9' {
+		end++
+	}
+	number, _ := strconv.Atoi(value[:end])
+	return number
+}
+
+// joinParagraphs appends text to existing text with a blank line between them
+func joinParagraphs(existing string, text string) string {
+	if existing == "" {
+		return text
+	}
+	return existing + "\n\n" + text
+}
@@ -7,7 +7,8 @@ import (
 )
 
 // ProcessISBNs takes a slice of ISBNs and queries Google Books for each one
-func ProcessISBNs(isbns []string, books []Book, errorsCleared bool, singleHit bool) []Book {
+// It returns the books, and how many were added (including new errors)
+func ProcessISBNs(isbns []string, books []Book, errorsCleared bool, singleHit bool) ([]Book, int) {
 	// Create a grid to track all books
 	grid := NewGrid([]string{"ISBN", "NEW?", "TITLE", "AUTHORS", "ERROR"})
 	grid.SetShowNumbers(true)
@@ -23,36 +24,17 @@ func ProcessISBNs(isbns []string, books []Book, errorsCleared bool, singleHit bo
 		}
 
 		// Look up the book
-		book, found, err := lookupBook(isbn, books, singleHit)
-		if found {
-			grid.AddRow(
-				isbn,
-				"-",
-				book.Title,
-				book.GetAuthorSortDisplay(),
-				book.ExceptionReason,
-			)
+		book, result := ProcessISBN(isbn, books, singleHit)
+		switch result.Outcome {
+		case LookupMatched:
+			grid.AddRow(isbn, "-", result.Title, result.Authors, result.Error)
 			matchedCount++
 			continue
-		}
-
-		if err != nil {
-			grid.AddRow(
-				isbn,
-				"Error",
-				"",
-				"",
-				err.Error(),
-			)
+		case LookupError:
+			grid.AddRow(isbn, "Error", "", "", result.Error)
 			errorCount++ // Only count new errors
-		} else {
-			grid.AddRow(
-				isbn,
-				"Yes",
-				book.Title,
-				book.GetAuthorSortDisplay(),
-				"",
-			)
+		default:
+			grid.AddRow(isbn, "Yes", result.Title, result.Authors, "")
 			newCount++
 		}
 		books = append(books, book)
@@ -78,28 +60,60 @@ func ProcessISBNs(isbns []string, books []Book, errorsCleared bool, singleHit bo
 	fmt.Printf("Ended with %d books in the database.\n", len(books))
 	fmt.Println()
 
-	return books
+	return books, newCount + errorCount
+}
+
+// Outcomes of processing an ISBN
+const (
+	LookupAdded   = "Added"
+	LookupMatched = "Matched"
+	LookupError   = "Error"
+)
+
+// LookupResult is the outcome of processing a single ISBN
+type LookupResult struct {
+	ISBN    string `json:"isbn"`
+	Outcome string `json:"outcome"`
+	Title   string `json:"title"`
+	Authors string `json:"authors"`
+	Error   string `json:"error"`
+}
+
+// ProcessISBN looks up a single ISBN unless we already have it
+// The book returned is either the existing one, the new one, or an error placeholder
+func ProcessISBN(isbn string, books []Book, singleHit bool) (Book, LookupResult) {
+	book, found, err := lookupBook(isbn, books, singleHit)
+	result := LookupResult{ISBN: isbn, Title: book.Title, Authors: book.GetAuthorSortDisplay()}
+	switch {
+	case found:
+		result.Outcome, result.Error = LookupMatched, book.ExceptionReason
+	case err != nil:
+		result.Outcome, result.Error = LookupError, err.Error()
+	default:
+		result.Outcome = LookupAdded
+	}
+	return book, result
 }
 
 // lookupBook checks if a book exists and if not, looks it up in Google Books
 func lookupBook(isbn string, books []Book, singleHit bool) (Book, bool, error) {
 	// Check if we already have this book
-	for _, book := range books {
-		if book.ISBN == isbn {
-			return book, true, nil
-		}
+	if book, found := findBookByISBN(books, isbn); found {
+		return book, true, nil
 	}
 
 	// Get the book from Google Books
 	gb, err := GetBookByISBN(isbn, singleHit)
 	if err != nil {
 		// Create a book with just the ISBN and error information
+		now := time.Now().UTC().Format(time.RFC3339)
 		book := Book{
 			ID:              "",
 			ISBN:            isbn,
 			IsException:     true,
 			ExceptionReason: err.Error(),
-			ModifiedUtc:     time.Now().UTC().Format(time.RFC3339),
+			AddedUtc:        now,
+			ModifiedUtc:     now,
 		}
 		return book, false, err
 	}
@@ -109,20 +123,78 @@ func lookupBook(isbn string, books []Book, singleHit bool) (Book, bool, error) {
 	return book, false, nil
 }
 
+// findBookByISBN returns the book with the given ISBN, if we already have it
+// ISBN-10 and ISBN-13 forms of the same ISBN match each other (eg "033026656X" and "9780330266567")
+func findBookByISBN(books []Book, isbn string) (Book, bool) {
+	isbn13 := getISBN13(isbn)
+	for _, book := range books {
+		if book.ISBN == isbn || (isbn13 != "" && getISBN13(book.ISBN) == isbn13) {
+			return book, true
+		}
+	}
+	return Book{}, false
+}
+
+// getISBN13 returns the ISBN-13 form of a valid ISBN-10 or ISBN-13, ignoring any punctuation
+// Anything else (such as the made-up ISBNs of books without one) returns an empty string
+func getISBN13(isbn string) string {
+	isbn = cleanISBN(isbn)
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, c := range isbn {
+			digit := int(c - '0')
+			if c == 'X' && i == 9 {
+				digit = 10
+			} else if c < '0' || c > '9' {
+				return ""
+			}
+			sum += digit * (10 - i)
+		}
+		if sum%11 != 0 {
+			return ""
+		}
+		isbn13 := "978" + isbn[:9]
+		return isbn13 + getISBN13CheckDigit(isbn13)
+	case 13:
+		if strings.Contains(isbn, "X") || getISBN13CheckDigit(isbn[:12]) != isbn[12:] {
+			return ""
+		}
+		return isbn
+	}
+	return ""
+}
+
+// getISBN13CheckDigit returns the check digit for the first 12 digits of an ISBN-13
+func getISBN13CheckDigit(digits string) string {
+	sum := 0
+	for i, c := range digits {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(c-'0') * weight
+	}
+	return fmt.Sprintf("%d", (10-sum%10)%10)
+}
+
 // mapGoogleBook converts a GoogleBook to our Book model
 func mapGoogleBook(isbn string, gb *GoogleBook) Book {
+	now := time.Now().UTC().Format(time.RFC3339)
+	authors := fixAuthorNames(gb.Authors)
 	return Book{
 		ID:            gb.ID,
 		ISBN:          isbn,
-		Title:         fixTitle(gb.Title),
-		Authors:       gb.Authors,
-		AuthorSort:    fixAuthorSorts(gb.Authors),
+		Title:         strings.TrimSpace(gb.Title),
+		Authors:       authors,
+		AuthorSort:    fixAuthorSorts(authors),
 		Genre:         gb.Categories,
 		Link:          gb.Link,
 		IsException:   false,
-		Status:        "Unread",
-		StatusIcon:    "U",
-		ModifiedUtc:   time.Now().UTC().Format(time.RFC3339),
+		Status:        KnownStatuses.Initial().Full(),
+		StatusIcon:    KnownStatuses.Initial().Code,
+		AddedUtc:      now,
+		ModifiedUtc:   now,
 		PublishedDate: gb.PublishedDate,
 		Publisher:     gb.Publisher,
 		PageCount:     gb.PageCount,
@@ -131,61 +203,26 @@ func mapGoogleBook(isbn string, gb *GoogleBook) Book {
 	}
 }
 
-// fixTitle moves "The " from the start to the end of the title
-func fixTitle(title string) string {
-	if strings.HasPrefix(title, "The ") {
-		return fmt.Sprintf("%s, the", title[4:])
+// fixAuthorNames returns the names the authors are known by, so that variants of a name
+// that have been merged (eg "J. R. R. Tolkien" and "J.R.R. Tolkien") are stored as one
+func fixAuthorNames(authors []string) []string {
+	names := []string{}
+	for _, author := range authors {
+		if name := KnownAuthors.GetAuthorName(author); name != "" {
+			names = append(names, name)
+		}
 	}
-	return title
+	return names
 }
 
-// fixAuthorSorts creates author sort strings (last name, first name) for each author
-// This is not internationalised, so it only works for English
-// It also handles initials (with or without periods)
+// fixAuthorSorts creates author sort strings (eg "Le Guin, Ursula K") for each author
+// Corrections made to an author's sort before are used instead (see AuthorStore)
 func fixAuthorSorts(authors []string) []string {
 	sorts := []string{}
-
-	// Process each author
 	for _, author := range authors {
-		// Get all the author segments
-		segments := []string{}
-		for _, p := range strings.Split(author, " ") {
-			if len(p) > 0 {
-				if strings.Contains(p, ".") {
-					// Initials should be capitalised and the period removed
-					segments = append(segments, strings.ToUpper(strings.TrimSpace(strings.ReplaceAll(p, ".", ""))))
-				} else {
-					segments = append(segments, strings.TrimSpace(p))
-				}
-			}
-		}
-
-		// Generate the resulting name
-		if len(segments) == 1 {
-			// Single segment, so just use the name
-			sorts = append(sorts, segments[0])
-		} else {
-			// Start with the last name
-			name := segments[len(segments)-1] + ","
-
-			// Multiple segments, so we need to figure out the initials
-			last := ""
-			for _, segment := range segments[:len(segments)-1] {
-				if len(last) != 1 {
-					name += " "
-				}
-				// Capitalise single letter initials
-				if len(segment) == 1 {
-					segment = strings.ToUpper(segment)
-				}
-				name += segment
-				last = segment
-			}
-
-			// Add the name to the list
-			sorts = append(sorts, name)
+		if sort := KnownAuthors.GetAuthorSort(author); sort != "" {
+			sorts = append(sorts, sort)
 		}
 	}
-
 	return sorts
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Job statuses
+const (
+	JobQueued  = "Queued"
+	JobRunning = "Running"
+	JobDone    = "Done"
+)
+
+// JobQueueSize is how many jobs can be waiting to run
+const JobQueueSize = 1000
+
+// JobHistory is how many finished jobs are kept for the jobs page
+const JobHistory = 100
+
+// Job is a list of ISBNs being looked up in the background
+type Job struct {
+	ID          int
+	Source      string
+	ISBNs       []string
+	SaveErrors  bool
+	Status      string
+	Results     []LookupResult
+	CreatedUtc  time.Time
+	FinishedUtc time.Time
+
+	// changed is closed (and replaced) whenever the job changes
+	changed chan struct{}
+}
+
+// JobSnapshot is a copy of a job's progress at a moment in time
+type JobSnapshot struct {
+	ID          int            `json:"id"`
+	Source      string         `json:"source"`
+	Status      string         `json:"status"`
+	Total       int            `json:"total"`
+	Processed   int            `json:"processed"`
+	Added       int            `json:"added"`
+	Matched     int            `json:"matched"`
+	Errors      int            `json:"errors"`
+	Results     []LookupResult `json:"results"`
+	CreatedUtc  string         `json:"createdUtc"`
+	FinishedUtc string         `json:"finishedUtc"`
+}
+
+// IsDone returns true if the job has finished
+func (j JobSnapshot) IsDone() bool {
+	return j.Status == JobDone
+}
+
+// JobQueue runs lookup jobs in the background, one at a time, in the order they were added
+// Books are added to the server's books file as they are found
+type JobQueue struct {
+	server  *Server
+	lock    sync.Mutex
+	jobs    []*Job
+	nextID  int
+	waiting chan *Job
+}
+
+// NewJobQueue creates a job queue for a server and starts it running
+func NewJobQueue(s *Server) *JobQueue {
+	q := &JobQueue{
+		server:  s,
+		nextID:  1,
+		waiting: make(chan *Job, JobQueueSize),
+	}
+	go q.run()
+	return q
+}
+
+// Add queues a job to look up the ISBNs, returning its ID
+// Errors are only saved to the books file (like the command line does) if saveErrors is set
+func (q *JobQueue) Add(isbns []string, source string, saveErrors bool) (int, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if len(q.waiting) == cap(q.waiting) {
+		return 0, errors.New("the job queue is full")
+	}
+
+	job := &Job{
+		ID:         q.nextID,
+		Source:     source,
+		ISBNs:      isbns,
+		SaveErrors: saveErrors,
+		Status:     JobQueued,
+		Results:    []LookupResult{},
+		CreatedUtc: time.Now().UTC(),
+		changed:    make(chan struct{}),
+	}
+	q.nextID++
+	q.jobs = append(q.jobs, job)
+	q.prune()
+	q.waiting <- job
+	return job.ID, nil
+}
+
+// Get returns the current progress of a job, along with a channel that is
+// closed when it next changes
+func (q *JobQueue) Get(id int) (JobSnapshot, <-chan struct{}, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for _, job := range q.jobs {
+		if job.ID == id {
+			return job.snapshot(), job.changed, true
+		}
+	}
+	return JobSnapshot{}, nil, false
+}
+
+// List returns the progress of every job, newest first
+func (q *JobQueue) List() []JobSnapshot {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	snapshots := make([]JobSnapshot, 0, len(q.jobs))
+	for i := len(q.jobs) - 1; i >= 0; i-- {
+		snapshots = append(snapshots, q.jobs[i].snapshot())
+	}
+	return snapshots
+}
+
+// Pending returns how many ISBNs are waiting to be looked up across all jobs
+func (q *JobQueue) Pending() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	pending := 0
+	for _, job := range q.jobs {
+		pending += len(job.ISBNs) - len(job.Results)
+	}
+	return pending
+}
+
+// run processes jobs until the server stops
+func (q *JobQueue) run() {
+	for job := range q.waiting {
+		q.update(job, func(j *Job) { j.Status = JobRunning })
+		for _, isbn := range job.ISBNs {
+			result := q.process(job, isbn)
+			q.update(job, func(j *Job) { j.Results = append(j.Results, result) })
+		}
+		q.update(job, func(j *Job) {
+			j.Status = JobDone
+			j.FinishedUtc = time.Now().UTC()
+		})
+		log.Printf("Job %d from %s finished (%d ISBNs)", job.ID, job.Source, len(job.ISBNs))
+	}
+}
+
+// process looks up a single ISBN for a job and adds the result to the books file
+func (q *JobQueue) process(job *Job, isbn string) LookupResult {
+	s := q.server
+
+	// The lookup is slow, so the books aren't locked while it happens
+	s.BooksLock.RLock()
+	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
+	book, result := ProcessISBN(isbn, books, false) // Use double-hit mode for better data
+	if result.Outcome == LookupMatched || (result.Outcome == LookupError && !job.SaveErrors) {
+		return result
+	}
+
+	// Add it to the latest books, unless it was added while being looked up
+	s.BooksLock.Lock()
+	defer s.BooksLock.Unlock()
+	books = LoadFile(s.Filename)
+	if existing, found := findBookByISBN(books, isbn); found {
+		result.Outcome, result.Title, result.Authors = LookupMatched, existing.Title, existing.GetAuthorSortDisplay()
+		return result
+	}
+	books = append(books, book)
+	if err := SaveBooks(s.Filename, books, s.Webhooks); err != nil {
+		result.Outcome, result.Error = LookupError, "Error saving file: "+err.Error()
+	}
+	return result
+}
+
+// update changes a job and lets anyone watching it know
+func (q *JobQueue) update(job *Job, change func(j *Job)) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	change(job)
+	close(job.changed)
+	job.changed = make(chan struct{})
+}
+
+// prune removes the oldest finished jobs beyond those kept for history
+// The lock must already be held
+func (q *JobQueue) prune() {
+	finished := 0
+	for _, job := range q.jobs {
+		if job.Status == JobDone {
+			finished++
+		}
+	}
+	kept := []*Job{}
+	for _, job := range q.jobs {
+		if job.Status == JobDone && finished > JobHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	q.jobs = kept
+}
+
+// snapshot copies the job's progress
+// The lock must already be held
+func (j *Job) snapshot() JobSnapshot {
+	snapshot := JobSnapshot{
+		ID:         j.ID,
+		Source:     j.Source,
+		Status:     j.Status,
+		Total:      len(j.ISBNs),
+		Processed:  len(j.Results),
+		Results:    make([]LookupResult, len(j.Results)),
+		CreatedUtc: j.CreatedUtc.Format(time.RFC3339),
+	}
+	copy(snapshot.Results, j.Results)
+	if !j.FinishedUtc.IsZero() {
+		snapshot.FinishedUtc = j.FinishedUtc.Format(time.RFC3339)
+	}
+	for _, result := range j.Results {
+		switch result.Outcome {
+		case LookupAdded:
+			snapshot.Added++
+		case LookupMatched:
+			snapshot.Matched++
+		case LookupError:
+			snapshot.Errors++
+		}
+	}
+	return snapshot
+}
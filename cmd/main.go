@@ -5,6 +5,8 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 func main() {
@@ -15,14 +17,42 @@ func main() {
 	// Parse command line arguments
 	parser := NewArgsParser()
 	parser.AddArgument("file", "JSON file containing book data", "", true)
+	parser.AddArgument("config", "JSON file containing settings", "", false)
 	parser.AddArgument("isbns", "Text file containing ISBNs to process", "", false)
+	parser.AddArgument("import", "CSV/TSV file containing books to import", "", false)
+	parser.AddArgument("import-map", "Import columns as a preset (generic, librarything, goodreads), a mapping file, or 'Column=field,...'", "", false)
+	parser.AddArgument("import-preview", "Show the first N mapped import rows without importing", "", false)
+	parser.AddArgument("export", "Export the books in a format (csv, tsv, bibtex, ris, csljson, marc, marcxml, goodreads)", "", false)
+	parser.AddArgument("export-to", "File to export to (defaults to next to the books file)", "", false)
+	parser.AddArgument("export-columns", "Comma-separated columns to export (defaults to the main ones)", "", false)
+	parser.AddArgument("select", "Only export these ISBNs (comma-separated, or a text file of ISBNs)", "", false)
+	parser.AddArgument("filter", "Only export books in a filter (all, reading, next, done, other, or from the settings)", "", false)
+	parser.AddArgument("sort", "Sort exported books by one or more comma-separated keys (eg genre,author,series)", "", false)
+	parser.AddArgument("publish", "Folder to publish the books to as a read-only static website", "", false)
 	parser.AddArgument("serve", "Local web server port for viewing the database", "", false)
+	parser.AddArgument("serve-public", "Web server port for a read-only public catalog (see settings)", "", false)
+	parser.AddArgument("hash-password", "Show the hash of a password, for adding a user to the settings", "", false)
 	parser.AddFlag("clear-errors", "Removes errored ISBNs so they retry")
 	parser.AddFlag("single-hit", "Only call the API once per ISBN (result quality varies)")
+	parser.AddFlag("descending", "Sort exported books in descending order")
+	parser.AddFlag("omit-notes", "Leave notes out of the published website")
+	parser.AddFlag("omit-exceptions", "Leave errored ISBNs out of the published website")
 	parser.AddFlag("alt-cookies", "Use insecure cookie (eg for Safari on Mac)")
+	parser.AddFlag("restore-titles", "Put 'The' back at the start of titles stored as eg 'Adversary, the'")
 	parser.ShowUsage()
 	parser.Parse(os.Args[1:])
 
+	// Hashing a password doesn't need a books file, so does nothing else
+	if parser.HasArgument("hash-password") {
+		hash, err := HashPassword(parser.GetArgument("hash-password"))
+		check(err)
+		fmt.Println()
+		fmt.Println("Password hash (use as the passwordHash of a user in the settings):")
+		fmt.Println(hash)
+		fmt.Println()
+		os.Exit(0)
+	}
+
 	// Check for command line errors
 	if parser.HasErrors() {
 		parser.PrintErrors()
@@ -36,19 +66,41 @@ func main() {
 	clearErrors := parser.GetFlag("clear-errors")
 	singleHit := parser.GetFlag("single-hit")
 	altCookies := parser.GetFlag("alt-cookies")
+	restoreTitles := parser.GetFlag("restore-titles")
+
+	// Load the settings, if provided
+	config := NewConfig()
+	if parser.HasArgument("config") {
+		fmt.Println()
+		fmt.Println("Loading settings from", parser.GetArgument("config"))
+		config = LoadConfig(parser.GetArgument("config"))
+	}
+	webhooks := NewWebhooks(config.Webhooks)
 
 	// Load the books from the JSON file
+	// Titles stored the old way (eg "Adversary, the") are only put back when asked for
 	fmt.Println()
 	fmt.Println()
 	fmt.Println("Loading books from", jsonFile)
+	if restoreTitles {
+		restored, err := RestoreTitles(jsonFile)
+		check(err)
+		fmt.Printf("Moved 'The' back to the start of %d title(s)\n", restored)
+	}
 	books := LoadFile(jsonFile)
 	fmt.Printf("Found %d book(s) in the database\n", len(books))
+	if old := countOldTitles(books); old > 0 {
+		fmt.Printf("%d title(s) look like they were stored the old way (eg 'Adversary, the'); run with --restore-titles to fix them\n", old)
+	}
+	var err error
+	KnownAuthors, err = NewAuthorStore(filepath.Join(filepath.Dir(jsonFile), AuthorsFilename))
+	check(err)
 	fmt.Println()
 
 	// Clear errors if requested
 	if clearErrors {
 		fmt.Println("Clearing errored ISBNs so they are retried")
-		removed, err := ClearErroredBooks(jsonFile)
+		removed, err := ClearErroredBooks(jsonFile, webhooks)
 		if err != nil {
 			fmt.Println()
 			fmt.Println("ERROR clearing errored ISBNs")
@@ -80,12 +132,13 @@ func main() {
 		}
 
 		// Process the ISBNs
-		books = ProcessISBNs(isbns, books, clearErrors, singleHit)
+		var added int
+		books, added = ProcessISBNs(isbns, books, clearErrors, singleHit)
 
 		// Save the updated books
-		// Only save if we have more books than we started with
-		if len(books) > len(LoadFile(jsonFile)) {
-			if err := SaveFile(jsonFile, books); err != nil {
+		// Only save if books were added
+		if added > 0 {
+			if err := SaveBooks(jsonFile, books, webhooks); err != nil {
 				fmt.Println()
 				fmt.Println("ERROR saving file")
 				check(err)
@@ -95,9 +148,116 @@ func main() {
 		}
 	}
 
-	// Start the server
-	if parser.HasArgument("serve") {
-		port := parser.GetArgument("serve")
+	// Import books from a CSV/TSV file
+	if parser.HasArgument("import") {
+		importFile := parser.GetArgument("import")
+		fmt.Println("Loading books to import from", importFile)
+		mapping := GetTabularMapping(parser.GetArgument("import-map"))
+		headers, records := LoadTabular(importFile)
+		rows := MapTabularRows(headers, records, mapping)
+		fmt.Printf("Found %d row(s) to consider for importing\n", len(rows))
+		fmt.Println("Only new ISBNs will be imported")
+		fmt.Println()
+
+		if parser.HasArgument("import-preview") {
+			// Preview only, so nothing is saved
+			count, err := strconv.Atoi(parser.GetArgument("import-preview"))
+			if err != nil || count < 1 {
+				fmt.Println("ERROR import preview needs a number of rows")
+				check(fmt.Errorf("invalid row count: %s", parser.GetArgument("import-preview")))
+			}
+			PreviewTabular(rows, count)
+		} else {
+			// Import the rows, only saving if books were added
+			var added int
+			books, added = ImportTabular(rows, books)
+			if added > 0 {
+				if err := SaveBooks(jsonFile, books, webhooks); err != nil {
+					fmt.Println()
+					fmt.Println("ERROR saving file")
+					check(err)
+				}
+				fmt.Println("Saved books to", jsonFile)
+				fmt.Println()
+			}
+		}
+	}
+
+	// Export the books
+	if parser.HasArgument("export") {
+		exporter, err := GetExporter(parser.GetArgument("export"))
+		check(err)
+		columns, err := ParseExportColumns(parser.GetArgument("export-columns"))
+		check(err)
+		exportFile := GetExportFilename(jsonFile, exporter)
+		if parser.HasArgument("export-to") {
+			exportFile = parser.GetArgument("export-to")
+		}
+
+		// Apply any filter and sort
+		exportBooks := books
+		if parser.HasArgument("filter") {
+			filter, ok := config.Filters.GetPopulatedFilter(parser.GetArgument("filter"), books)
+			if !ok {
+				check(fmt.Errorf("unknown filter: %s", parser.GetArgument("filter")))
+			}
+			exportBooks = filter.Books
+		}
+		if parser.HasArgument("select") {
+			selection := parser.GetArgument("select")
+			isbns := strings.Split(selection, ",")
+			if exists, f, err := CheckFileExists(selection); err == nil && exists {
+				f.Close()
+				isbns = LoadISBNs(selection)
+			}
+			exportBooks = SelectBooksByISBN(exportBooks, isbns)
+		}
+		if parser.HasArgument("sort") {
+			levels, err := ParseSortLevels(parser.GetArgument("sort"), "")
+			check(err)
+			for i := range levels {
+				levels[i].Descending = parser.GetFlag("descending")
+			}
+			SortBooks(exportBooks, levels)
+		}
+
+		fmt.Printf("Exporting %d book(s) as %s to %s\n", len(exportBooks), exporter.Name, exportFile)
+		if err := ExportToFile(exportFile, exportBooks, exporter, ExportOptions{Columns: columns, Config: config}); err != nil {
+			fmt.Println()
+			fmt.Println("ERROR exporting books")
+			check(err)
+		}
+		fmt.Println()
+	}
+
+	// Publish the static website
+	if parser.HasArgument("publish") {
+		publishDir := parser.GetArgument("publish")
+		fmt.Println("Publishing website to", publishDir)
+		options := PublishOptions{
+			OmitNotes:      parser.GetFlag("omit-notes"),
+			OmitExceptions: parser.GetFlag("omit-exceptions"),
+			Filters:        config.Filters,
+		}
+		pages, err := Publish(publishDir, jsonFile, books, options)
+		if err != nil {
+			fmt.Println()
+			fmt.Println("ERROR publishing website")
+			check(err)
+		}
+		fmt.Printf("Published %d page(s); open index.html in a browser to view\n", pages)
+		fmt.Println()
+	}
+
+	// Start the servers (the website, the public catalog, or both)
+	// They share the books file, so they share its lock too
+	servers := []*Server{}
+	booksLock := &sync.RWMutex{}
+	for _, argument := range []string{"serve", "serve-public"} {
+		if !parser.HasArgument(argument) {
+			continue
+		}
+		port := parser.GetArgument(argument)
 		portInt, err := strconv.Atoi(port)
 		if err != nil {
 			fmt.Println("ERROR converting port to int")
@@ -111,12 +271,28 @@ func main() {
 			check(err)
 		}
 
-		server, err := NewServer(portInt, absPath, altCookies)
+		server, err := NewServer(portInt, absPath, booksLock, altCookies, config, webhooks, argument == "serve-public")
 		if err != nil {
 			fmt.Println("ERROR creating server")
 			check(err)
 		}
-		server.Start()
+		servers = append(servers, server)
+	}
+	var running sync.WaitGroup
+	for _, server := range servers {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			server.Start()
+		}()
+	}
+	running.Wait()
+
+	// Let any webhooks finish (or give up) before exiting
+	if pending := webhooks.Pending(); pending > 0 {
+		fmt.Printf("Waiting for %d webhook delivery(s) to finish\n", pending)
+		webhooks.Wait()
+		fmt.Println()
 	}
 
 	fmt.Println("Done.")
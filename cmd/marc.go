@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MARC21 (ISO 2709) structural characters
+const (
+	MarcFieldTerminator  = 0x1E
+	MarcRecordTerminator = 0x1D
+	MarcSubfieldMarker   = 0x1F
+	MarcLeaderLength     = 24
+	MarcDirectoryEntry   = 12
+	MarcMaxRecordLength  = 99999
+	MarcMaxFieldLength   = 9999
+)
+
+// MarcRecord is a single MARC21 bibliographic record
+type MarcRecord struct {
+	Leader string
+	Fields []MarcField
+}
+
+// MarcField is either a control field (tags 001-009, which have a Value)
+// or a data field (which has indicators and subfields)
+type MarcField struct {
+	Tag       string
+	Value     string
+	Ind1      byte
+	Ind2      byte
+	Subfields []MarcSubfield
+}
+
+// MarcSubfield is a single coded value within a data field
+type MarcSubfield struct {
+	Code  byte
+	Value string
+}
+
+// IsControl returns true if the field is a control field
+func (f *MarcField) IsControl() bool {
+	return strings.HasPrefix(f.Tag, "00")
+}
+
+// GetSubfield returns the first value of a subfield, or an empty string
+func (f *MarcField) GetSubfield(code byte) string {
+	for _, subfield := range f.Subfields {
+		if subfield.Code == code {
+			return subfield.Value
+		}
+	}
+	return ""
+}
+
+// GetFields returns all the fields in the record with the given tag
+func (r *MarcRecord) GetFields(tag string) []MarcField {
+	fields := []MarcField{}
+	for _, field := range r.Fields {
+		if field.Tag == tag {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// EncodeMarc writes the records in ISO 2709 format, filling in the
+// record length and base address of each leader as it goes
+func EncodeMarc(records []MarcRecord) ([]byte, error) {
+	var out bytes.Buffer
+	for _, record := range records {
+		if len(record.Leader) != MarcLeaderLength {
+			return nil, fmt.Errorf("leader must be %d characters: '%s'", MarcLeaderLength, record.Leader)
+		}
+
+		// Build the directory and the field data together
+		var directory, data bytes.Buffer
+		for _, field := range record.Fields {
+			if len(field.Tag) != 3 {
+				return nil, fmt.Errorf("tag must be 3 characters: '%s'", field.Tag)
+			}
+			encoded := encodeMarcField(field)
+			if len(encoded) > MarcMaxFieldLength {
+				return nil, fmt.Errorf("field %s is too long (%d bytes)", field.Tag, len(encoded))
+			}
+			fmt.Fprintf(&directory, "%s%04d%05d", field.Tag, len(encoded), data.Len())
+			data.Write(encoded)
+		}
+		directory.WriteByte(MarcFieldTerminator)
+
+		// Now the sizes are known the leader can be completed
+		baseAddress := MarcLeaderLength + directory.Len()
+		recordLength := baseAddress + data.Len() + 1
+		if recordLength > MarcMaxRecordLength {
+			return nil, fmt.Errorf("record is too long (%d bytes)", recordLength)
+		}
+		leader := fmt.Sprintf("%05d%s%05d%s", recordLength, record.Leader[5:12], baseAddress, record.Leader[17:])
+
+		out.WriteString(leader)
+		out.Write(directory.Bytes())
+		out.Write(data.Bytes())
+		out.WriteByte(MarcRecordTerminator)
+	}
+	return out.Bytes(), nil
+}
+
+// DecodeMarc reads records in ISO 2709 format
+func DecodeMarc(content []byte) ([]MarcRecord, error) {
+	records := []MarcRecord{}
+	for len(content) > 0 {
+		// Skip any whitespace between records (eg a trailing newline)
+		content = bytes.TrimLeft(content, " \r\n")
+		if len(content) == 0 {
+			break
+		}
+		if len(content) < MarcLeaderLength {
+			return nil, errors.New("truncated leader")
+		}
+
+		// The leader says how long the record is and where its data starts
+		recordLength, err := strconv.Atoi(string(content[0:5]))
+		if err != nil || recordLength > len(content) || recordLength <= MarcLeaderLength {
+			return nil, fmt.Errorf("invalid record length in record %d", len(records)+1)
+		}
+		baseAddress, err := strconv.Atoi(string(content[12:17]))
+		if err != nil || baseAddress >= recordLength || baseAddress <= MarcLeaderLength {
+			return nil, fmt.Errorf("invalid base address in record %d", len(records)+1)
+		}
+		raw := content[:recordLength]
+		content = content[recordLength:]
+		if raw[recordLength-1] != MarcRecordTerminator {
+			return nil, fmt.Errorf("missing record terminator in record %d", len(records)+1)
+		}
+
+		// Read the fields listed in the directory
+		record := MarcRecord{Leader: string(raw[:MarcLeaderLength])}
+		directory := raw[MarcLeaderLength : baseAddress-1]
+		if len(directory)%MarcDirectoryEntry != 0 {
+			return nil, fmt.Errorf("invalid directory in record %d", len(records)+1)
+		}
+		for i := 0; i < len(directory); i += MarcDirectoryEntry {
+			entry := directory[i : i+MarcDirectoryEntry]
+			length, lengthErr := strconv.Atoi(string(entry[3:7]))
+			start, startErr := strconv.Atoi(string(entry[7:12]))
+			if lengthErr != nil || startErr != nil || baseAddress+start+length > recordLength-1 {
+				return nil, fmt.Errorf("invalid directory entry %s in record %d", entry[0:3], len(records)+1)
+			}
+			data := raw[baseAddress+start : baseAddress+start+length]
+			field, err := decodeMarcField(string(entry[0:3]), data)
+			if err != nil {
+				return nil, fmt.Errorf("%w in record %d", err, len(records)+1)
+			}
+			record.Fields = append(record.Fields, field)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// encodeMarcField returns the bytes of a field, including its terminator
+func encodeMarcField(field MarcField) []byte {
+	var sb bytes.Buffer
+	if field.IsControl() {
+		sb.WriteString(field.Value)
+	} else {
+		sb.WriteByte(field.Ind1)
+		sb.WriteByte(field.Ind2)
+		for _, subfield := range field.Subfields {
+			sb.WriteByte(MarcSubfieldMarker)
+			sb.WriteByte(subfield.Code)
+			sb.WriteString(subfield.Value)
+		}
+	}
+	sb.WriteByte(MarcFieldTerminator)
+	return sb.Bytes()
+}
+
+// decodeMarcField parses the bytes of a field, including its terminator
+func decodeMarcField(tag string, data []byte) (MarcField, error) {
+	field := MarcField{Tag: tag}
+	if len(data) == 0 || data[len(data)-1] != MarcFieldTerminator {
+		return field, fmt.Errorf("missing terminator in field %s", tag)
+	}
+	data = data[:len(data)-1]
+	if field.IsControl() {
+		field.Value = string(data)
+		return field, nil
+	}
+	if len(data) < 2 {
+		return field, fmt.Errorf("missing indicators in field %s", tag)
+	}
+	field.Ind1, field.Ind2 = data[0], data[1]
+	for _, part := range bytes.Split(data[2:], []byte{MarcSubfieldMarker})[1:] {
+		if len(part) == 0 {
+			return field, fmt.Errorf("empty subfield in field %s", tag)
+		}
+		field.Subfields = append(field.Subfields, MarcSubfield{Code: part[0], Value: string(part[1:])})
+	}
+	return field, nil
+}
+
+// marcXMLCollection is the MARCXML (MARC21 slim) document structure
+type marcXMLCollection struct {
+	XMLName xml.Name        `xml:"http://www.loc.gov/MARC21/slim collection"`
+	Records []marcXMLRecord `xml:"record"`
+}
+
+type marcXMLRecord struct {
+	Leader        string                `xml:"leader"`
+	ControlFields []marcXMLControlField `xml:"controlfield"`
+	DataFields    []marcXMLDataField    `xml:"datafield"`
+}
+
+type marcXMLControlField struct {
+	Tag   string `xml:"tag,attr"`
+	Value string `xml:",chardata"`
+}
+
+type marcXMLDataField struct {
+	Tag       string            `xml:"tag,attr"`
+	Ind1      string            `xml:"ind1,attr"`
+	Ind2      string            `xml:"ind2,attr"`
+	Subfields []marcXMLSubfield `xml:"subfield"`
+}
+
+type marcXMLSubfield struct {
+	Code  string `xml:"code,attr"`
+	Value string `xml:",chardata"`
+}
+
+// EncodeMarcXML writes the records as a MARCXML collection
+// Control fields always precede data fields in MARCXML, which is also MARC21's tag order
+func EncodeMarcXML(records []MarcRecord) ([]byte, error) {
+	collection := marcXMLCollection{}
+	for _, record := range records {
+		x := marcXMLRecord{Leader: record.Leader}
+		for _, field := range record.Fields {
+			if field.IsControl() {
+				x.ControlFields = append(x.ControlFields, marcXMLControlField{Tag: field.Tag, Value: field.Value})
+				continue
+			}
+			df := marcXMLDataField{Tag: field.Tag, Ind1: string(field.Ind1), Ind2: string(field.Ind2)}
+			for _, subfield := range field.Subfields {
+				df.Subfields = append(df.Subfields, marcXMLSubfield{Code: string(subfield.Code), Value: subfield.Value})
+			}
+			x.DataFields = append(x.DataFields, df)
+		}
+		collection.Records = append(collection.Records, x)
+	}
+
+	content, err := xml.MarshalIndent(collection, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(content, '\n')...), nil
+}
+
+// DecodeMarcXML reads records from a MARCXML collection
+func DecodeMarcXML(content []byte) ([]MarcRecord, error) {
+	var collection marcXMLCollection
+	if err := xml.Unmarshal(content, &collection); err != nil {
+		return nil, err
+	}
+	records := []MarcRecord{}
+	for _, x := range collection.Records {
+		record := MarcRecord{Leader: x.Leader}
+		for _, cf := range x.ControlFields {
+			record.Fields = append(record.Fields, MarcField{Tag: cf.Tag, Value: cf.Value})
+		}
+		for _, df := range x.DataFields {
+			if len(df.Ind1) != 1 || len(df.Ind2) != 1 {
+				return nil, fmt.Errorf("invalid indicators in field %s", df.Tag)
+			}
+			field := MarcField{Tag: df.Tag, Ind1: df.Ind1[0], Ind2: df.Ind2[0]}
+			for _, sf := range df.Subfields {
+				if len(sf.Code) != 1 {
+					return nil, fmt.Errorf("invalid subfield code in field %s", df.Tag)
+				}
+				field.Subfields = append(field.Subfields, MarcSubfield{Code: sf.Code[0], Value: sf.Value})
+			}
+			record.Fields = append(record.Fields, field)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// CompareMarcRecords checks that the records read back match the records written
+// The record length and base address are ignored as they are calculated on writing
+func CompareMarcRecords(written []MarcRecord, read []MarcRecord) error {
+	if len(written) != len(read) {
+		return fmt.Errorf("wrote %d record(s) but read back %d", len(written), len(read))
+	}
+	for i := range written {
+		w, r := written[i], read[i]
+		if len(r.Leader) != MarcLeaderLength || w.Leader[5:12] != r.Leader[5:12] || w.Leader[17:] != r.Leader[17:] {
+			return fmt.Errorf("record %d leader differs: '%s' vs '%s'", i+1, w.Leader, r.Leader)
+		}
+		if len(w.Fields) != len(r.Fields) {
+			return fmt.Errorf("record %d wrote %d field(s) but read back %d", i+1, len(w.Fields), len(r.Fields))
+		}
+		for f := range w.Fields {
+			wf, rf := w.Fields[f], r.Fields[f]
+			same := wf.Tag == rf.Tag && wf.Value == rf.Value && len(wf.Subfields) == len(rf.Subfields)
+			if same && !wf.IsControl() {
+				same = wf.Ind1 == rf.Ind1 && wf.Ind2 == rf.Ind2
+				for s := 0; same && s < len(wf.Subfields); s++ {
+					same = wf.Subfields[s] == rf.Subfields[s]
+				}
+			}
+			if !same {
+				return fmt.Errorf("record %d field %s differs after reading back", i+1, wf.Tag)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OPDS content types
+const (
+	OpdsNavigationType  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	OpdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	Opds2Type           = "application/opds+json"
+)
+
+// opdsBookFilters maps the query parameters of a books feed to the index they select from
+var opdsBookFilters = map[string]string{
+	"author": "authors",
+	"series": "series",
+	"genre":  "genres",
+}
+
+// OpdsFeed is a catalog feed, independent of the OPDS version it is sent as
+// Navigation feeds have Links to other feeds and acquisition feeds have Books
+type OpdsFeed struct {
+	Path    string
+	Title   string
+	Updated time.Time
+	Links   []OpdsLink
+	Books   []Book
+}
+
+// OpdsLink is a navigation entry pointing to another feed
+type OpdsLink struct {
+	Title      string
+	Path       string
+	Count      int
+	IsBookList bool
+}
+
+// IsNavigation returns true if the feed lists other feeds rather than books
+func (f *OpdsFeed) IsNavigation() bool {
+	return f.Links != nil
+}
+
+// GetOpdsFeed returns the feed with the given name (eg "catalog") for the books
+// Books feeds are chosen by a filter, author, series, or genre in the query string
+func GetOpdsFeed(name string, query url.Values, books []Book, filters BookFilters) (OpdsFeed, error) {
+	// Only books with details can be listed
+	listed := []Book{}
+	for _, book := range books {
+		if !book.IsException {
+			listed = append(listed, book)
+		}
+	}
+	feed := OpdsFeed{Path: name, Updated: getLatestModified(listed)}
+
+	switch name {
+	case "catalog":
+		// The root lists the filters then the indexes
+		feed.Title = "MFW Books DB"
+		feed.Links = []OpdsLink{}
+		for _, filterKey := range filters.Keys() {
+			filter, _ := filters.GetPopulatedFilter(filterKey, listed)
+			feed.Links = append(feed.Links, OpdsLink{
+				Title:      filter.Name,
+				Path:       "books?filter=" + url.QueryEscape(filterKey),
+				Count:      len(filter.Books),
+				IsBookList: true,
+			})
+		}
+		for _, index := range IndexKeys {
+			feed.Links = append(feed.Links, OpdsLink{
+				Title: capitalizeWords(index),
+				Path:  index,
+				Count: len(GetIndexEntries(listed, index)),
+			})
+		}
+		return feed, nil
+
+	case "authors", "series", "genres":
+		// An index lists each name with a link to its books
+		feed.Title = capitalizeWords(name)
+		feed.Links = []OpdsLink{}
+		for _, entry := range GetIndexEntries(listed, name) {
+			feed.Links = append(feed.Links, OpdsLink{
+				Title:      entry.Name,
+				Path:       "books?" + getOpdsBookParameter(name) + "=" + url.QueryEscape(entry.Name),
+				Count:      len(entry.Books),
+				IsBookList: true,
+			})
+		}
+		return feed, nil
+
+	case "books":
+		feed.Path = name + "?" + query.Encode()
+		if filterKey := query.Get("filter"); filterKey != "" {
+			filter, ok := filters.GetPopulatedFilter(filterKey, listed)
+			if !ok {
+				return feed, fmt.Errorf("unknown filter '%s'", filterKey)
+			}
+			sortBooksByFallbackOrder(filter.Books)
+			feed.Title, feed.Books = filter.Name, filter.Books
+			return feed, nil
+		}
+		for parameter, index := range opdsBookFilters {
+			value := query.Get(parameter)
+			if value == "" {
+				continue
+			}
+			for _, entry := range GetIndexEntries(listed, index) {
+				if strings.EqualFold(entry.Name, strings.TrimSpace(value)) {
+					feed.Title, feed.Books = entry.Name, entry.Books
+					return feed, nil
+				}
+			}
+			return feed, fmt.Errorf("no books found for %s '%s'", parameter, value)
+		}
+		return feed, fmt.Errorf("expected a filter, author, series, or genre")
+	}
+	return feed, fmt.Errorf("unknown feed '%s'", name)
+}
+
+// getOpdsBookParameter returns the books feed query parameter for an index
+func getOpdsBookParameter(index string) string {
+	for parameter, name := range opdsBookFilters {
+		if name == index {
+			return parameter
+		}
+	}
+	return index
+}
+
+// getLatestModified returns the most recent modification time of the books,
+// or the current time if there are none
+func getLatestModified(books []Book) time.Time {
+	latest := time.Time{}
+	for _, book := range books {
+		if modified := getModifiedTime(&book); modified.After(latest) {
+			latest = modified
+		}
+	}
+	if latest.IsZero() {
+		return time.Now().UTC()
+	}
+	return latest
+}
+
+// getModifiedTime returns when the book was last modified, or the zero time if unknown
+func getModifiedTime(book *Book) time.Time {
+	modified, err := time.Parse(time.RFC3339, book.ModifiedUtc)
+	if err != nil {
+		return time.Time{}
+	}
+	return modified.UTC()
+}
+
+// GetBaseURL returns the scheme and host a request was made to, like 'http://localhost:8000'
+func GetBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// opdsAuthor is a book's author with their display and sort names
+type opdsAuthor struct {
+	Name   string
+	SortAs string
+}
+
+// getOpdsAuthors pairs the book's display authors with its author sorts where they line up
+func getOpdsAuthors(book *Book) []opdsAuthor {
+	authors := []opdsAuthor{}
+	for i, name := range book.Authors {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		author := opdsAuthor{Name: name}
+		if len(book.Authors) == len(book.AuthorSort) {
+			author.SortAs = strings.TrimSpace(book.AuthorSort[i])
+		}
+		authors = append(authors, author)
+	}
+	return authors
+}
+
+// getOpdsGenres returns the book's non-blank genres
+func getOpdsGenres(book *Book) []string {
+	genres := []string{}
+	for _, genre := range book.Genre {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			genres = append(genres, genre)
+		}
+	}
+	return genres
+}
+
+// atomFeed is an Atom feed, with the namespaces needed for OPDS 1.2 catalogs
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"feed"`
+	Xmlns     string      `xml:"xmlns,attr"`
+	XmlnsDC   string      `xml:"xmlns:dc,attr,omitempty"`
+	XmlnsOPDS string      `xml:"xmlns:opds,attr,omitempty"`
+	XmlnsThr  string      `xml:"xmlns:thr,attr,omitempty"`
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Author    atomAuthor  `xml:"author"`
+	Links     []atomLink  `xml:"link"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel   string `xml:"rel,attr"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	Count int    `xml:"thr:count,attr,omitempty"`
+}
+
+type atomCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+type atomText struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Updated    string         `xml:"updated"`
+	Authors    []atomAuthor   `xml:"author"`
+	Identifier string         `xml:"dc:identifier,omitempty"`
+	Language   string         `xml:"dc:language,omitempty"`
+	Publisher  string         `xml:"dc:publisher,omitempty"`
+	Issued     string         `xml:"dc:issued,omitempty"`
+	Categories []atomCategory `xml:"category"`
+	Summary    *atomText      `xml:"summary,omitempty"`
+	Content    *atomText      `xml:"content,omitempty"`
+	Links      []atomLink     `xml:"link"`
+}
+
+// WriteOpdsAtom writes the feed as OPDS 1.2 (Atom) with links relative to the base URL
+func WriteOpdsAtom(w io.Writer, feed OpdsFeed, baseURL string) error {
+	feedURL := func(path string) string { return baseURL + "/opds/v1/" + path }
+	updated := feed.Updated.Format(time.RFC3339)
+	selfType := OpdsAcquisitionType
+	if feed.IsNavigation() {
+		selfType = OpdsNavigationType
+	}
+
+	x := atomFeed{
+		Xmlns:     "http://www.w3.org/2005/Atom",
+		XmlnsDC:   "http://purl.org/dc/terms/",
+		XmlnsOPDS: "http://opds-spec.org/2010/catalog",
+		XmlnsThr:  "http://purl.org/syndication/thread/1.0",
+		ID:        feedURL(feed.Path),
+		Title:     feed.Title,
+		Updated:   updated,
+		Author:    atomAuthor{Name: "MFW Books DB"},
+		Links: []atomLink{
+			{Rel: "self", Href: feedURL(feed.Path), Type: selfType},
+			{Rel: "start", Href: feedURL("catalog"), Type: OpdsNavigationType},
+			{Rel: "alternate", Href: baseURL + "/", Type: "text/html"},
+		},
+	}
+
+	// Navigation entries link to other feeds
+	for _, link := range feed.Links {
+		linkType := OpdsNavigationType
+		if link.IsBookList {
+			linkType = OpdsAcquisitionType
+		}
+		x.Entries = append(x.Entries, atomEntry{
+			Title:   link.Title,
+			ID:      feedURL(link.Path),
+			Updated: updated,
+			Content: &atomText{Type: "text", Value: getOpdsCountText(link)},
+			Links:   []atomLink{{Rel: "subsection", Href: feedURL(link.Path), Type: linkType, Count: link.Count}},
+		})
+	}
+
+	// Acquisition entries describe books and link back to the website
+	for _, book := range feed.Books {
+		entry := atomEntry{
+			Title:      book.Title,
+			ID:         "urn:isbn:" + book.ISBN,
+			Updated:    updated,
+			Identifier: "urn:isbn:" + book.ISBN,
+			Language:   book.Language,
+			Publisher:  book.Publisher,
+			Issued:     book.PublishedDate,
+			Links:      getOpdsAtomBookLinks(&book, baseURL),
+		}
+		if modified := getModifiedTime(&book); !modified.IsZero() {
+			entry.Updated = modified.Format(time.RFC3339)
+		}
+		for _, author := range getOpdsAuthors(&book) {
+			entry.Authors = append(entry.Authors, atomAuthor{Name: author.Name})
+		}
+		for _, genre := range getOpdsGenres(&book) {
+			entry.Categories = append(entry.Categories, atomCategory{Term: genre, Label: genre})
+		}
+		if description := strings.TrimSpace(book.Description); description != "" {
+			entry.Summary = &atomText{Type: "text", Value: description}
+		}
+		x.Entries = append(x.Entries, entry)
+	}
+
+	content, err := xml.MarshalIndent(x, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	_, err = w.Write(append(content, '\n'))
+	return err
+}
+
+// getOpdsAtomBookLinks returns the links for a book entry
+// There are no files to download, so acquisition is a link to buy the book
+func getOpdsAtomBookLinks(book *Book, baseURL string) []atomLink {
+	links := []atomLink{
+		{Rel: "alternate", Href: baseURL + "/books/view/" + url.PathEscape(book.ISBN), Type: "text/html", Title: "MFW Books DB"},
+		{Rel: "http://opds-spec.org/acquisition/buy", Href: book.GetLinkWaterstones(), Type: "text/html", Title: "Waterstones"},
+		{Rel: "related", Href: book.GetLinkOpenLibrary(), Type: "text/html", Title: "OpenLibrary"},
+		{Rel: "related", Href: book.GetLinkGoogleBooksView(), Type: "text/html", Title: "Google Books"},
+	}
+	return links
+}
+
+// getOpdsCountText returns a description of how many items a navigation link leads to
+func getOpdsCountText(link OpdsLink) string {
+	if link.IsBookList {
+		return fmt.Sprintf("%d book(s)", link.Count)
+	}
+	return fmt.Sprintf("%d entries", link.Count)
+}
+
+// opds2Feed is an OPDS 2.0 catalog feed
+type opds2Feed struct {
+	Metadata     opds2FeedMetadata  `json:"metadata"`
+	Links        []opds2Link        `json:"links"`
+	Navigation   []opds2Link        `json:"navigation,omitempty"`
+	Publications []opds2Publication `json:"publications,omitempty"`
+}
+
+type opds2FeedMetadata struct {
+	Title         string `json:"title"`
+	Modified      string `json:"modified"`
+	NumberOfItems int    `json:"numberOfItems"`
+}
+
+type opds2Link struct {
+	Rel        string           `json:"rel,omitempty"`
+	Href       string           `json:"href"`
+	Type       string           `json:"type,omitempty"`
+	Title      string           `json:"title,omitempty"`
+	Properties *opds2Properties `json:"properties,omitempty"`
+}
+
+type opds2Properties struct {
+	NumberOfItems int `json:"numberOfItems"`
+}
+
+type opds2Publication struct {
+	Metadata opds2Metadata `json:"metadata"`
+	Links    []opds2Link   `json:"links"`
+}
+
+type opds2Metadata struct {
+	Type          string          `json:"@type"`
+	Title         string          `json:"title"`
+	SortAs        string          `json:"sortAs,omitempty"`
+	Identifier    string          `json:"identifier"`
+	Modified      string          `json:"modified,omitempty"`
+	Published     string          `json:"published,omitempty"`
+	Language      string          `json:"language,omitempty"`
+	Publisher     string          `json:"publisher,omitempty"`
+	Author        []opds2Contrib  `json:"author,omitempty"`
+	Subject       []opds2Contrib  `json:"subject,omitempty"`
+	Description   string          `json:"description,omitempty"`
+	NumberOfPages int             `json:"numberOfPages,omitempty"`
+	BelongsTo     *opds2BelongsTo `json:"belongsTo,omitempty"`
+}
+
+type opds2Contrib struct {
+	Name     string  `json:"name"`
+	SortAs   string  `json:"sortAs,omitempty"`
+	Position float64 `json:"position,omitempty"`
+}
+
+type opds2BelongsTo struct {
+	Series []opds2Contrib `json:"series"`
+}
+
+// WriteOpds2 writes the feed as OPDS 2.0 (JSON) with links relative to the base URL
+func WriteOpds2(w io.Writer, feed OpdsFeed, baseURL string) error {
+	feedURL := func(path string) string { return baseURL + "/opds/v2/" + path }
+	x := opds2Feed{
+		Metadata: opds2FeedMetadata{
+			Title:         feed.Title,
+			Modified:      feed.Updated.Format(time.RFC3339),
+			NumberOfItems: len(feed.Links) + len(feed.Books),
+		},
+		Links: []opds2Link{
+			{Rel: "self", Href: feedURL(feed.Path), Type: Opds2Type},
+			{Rel: "start", Href: feedURL("catalog"), Type: Opds2Type},
+			{Rel: "alternate", Href: baseURL + "/", Type: "text/html"},
+		},
+	}
+
+	// Navigation links to other feeds
+	for _, link := range feed.Links {
+		x.Navigation = append(x.Navigation, opds2Link{
+			Rel:        "subsection",
+			Href:       feedURL(link.Path),
+			Type:       Opds2Type,
+			Title:      link.Title,
+			Properties: &opds2Properties{NumberOfItems: link.Count},
+		})
+	}
+
+	// Publications describe books and link back to the website
+	for _, book := range feed.Books {
+		metadata := opds2Metadata{
+			Type:          "http://schema.org/Book",
+			Title:         book.Title,
+			SortAs:        book.Title,
+			Identifier:    "urn:isbn:" + book.ISBN,
+			Published:     book.PublishedDate,
+			Language:      book.Language,
+			Publisher:     book.Publisher,
+			Description:   strings.TrimSpace(book.Description),
+			NumberOfPages: book.PageCount,
+		}
+		if modified := getModifiedTime(&book); !modified.IsZero() {
+			metadata.Modified = modified.Format(time.RFC3339)
+		}
+		for _, author := range getOpdsAuthors(&book) {
+			metadata.Author = append(metadata.Author, opds2Contrib{Name: author.Name, SortAs: author.SortAs})
+		}
+		for _, genre := range getOpdsGenres(&book) {
+			metadata.Subject = append(metadata.Subject, opds2Contrib{Name: genre})
+		}
+		if book.Series != "" {
+			series := opds2Contrib{Name: book.Series}
+			if position, err := strconv.ParseFloat(strings.TrimSpace(book.Sequence), 64); err == nil {
+				series.Position = position
+			}
+			metadata.BelongsTo = &opds2BelongsTo{Series: []opds2Contrib{series}}
+		}
+
+		publication := opds2Publication{Metadata: metadata}
+		for _, link := range getOpdsAtomBookLinks(&book, baseURL) {
+			publication.Links = append(publication.Links, opds2Link{Rel: link.Rel, Href: link.Href, Type: link.Type, Title: link.Title})
+		}
+		x.Publications = append(x.Publications, publication)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(x)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Preferences are how someone likes the books shown
+type Preferences struct {
+	Filter        string   `json:"filter"`
+	SortField     string   `json:"sortField"`
+	SortDirection string   `json:"sortDirection"`
+	Columns       []string `json:"columns"`
+	PageSize      int      `json:"pageSize"`
+	UpdatedUtc    string   `json:"updatedUtc"`
+}
+
+// HomeColumn is a home page column that can be hidden (the ISBN and title are always shown)
+type HomeColumn struct {
+	Name   string
+	Header string
+}
+
+// HomeColumns are the home page columns that can be hidden, in the order shown
+var HomeColumns = []HomeColumn{
+	{"status", "Status"},
+	{"author", "Author"},
+	{"series", "Series"},
+	{"rating", "Rating"},
+	{"genre", "Genre"},
+	{"links", "Links"},
+}
+
+// PageSizes are the choices for how many books to show on each page (0 means all of them)
+var PageSizes = []int{0, 25, 50, 100, 250}
+
+// PreferencesFilename is kept next to the books file, and holds preferences when they are kept on the server
+const PreferencesFilename = "mfw-preferences.json"
+
+// BrowserCookie holds the ID that a browser's preferences are kept against on the server
+// The ID is chosen by the server and signed, so browsers can't make up their own
+const BrowserCookie = "mfw-browser"
+
+// PreferencesMaxAge is how long (in seconds) unused preferences are kept
+const PreferencesMaxAge = 60 * 60 * 24 * 365
+
+// PreferencesMaxBrowsers is how many browsers' preferences are kept on the server
+// Beyond that the least recently changed are removed, so the file can't grow without limit
+const PreferencesMaxBrowsers = 1000
+
+// PreferencesCookieMaxAge is how long (in seconds) preferences kept in cookies last
+const PreferencesCookieMaxAge = 86400 // 24 hours
+
+// ShowsColumn returns true if the column should be shown (all are shown until some are chosen)
+func (p Preferences) ShowsColumn(column string) bool {
+	return p.Columns == nil || slices.Contains(p.Columns, column)
+}
+
+// Paging is which of the books are shown on a page of the home page
+type Paging struct {
+	Page     int
+	Pages    int
+	PageSize int
+	Total    int
+
+	// First and Last are the slice bounds of the books on the page
+	First int
+	Last  int
+}
+
+// GetPaging returns the paging for a page of books (a page size of zero shows them all on one page)
+// Pages outside the range show the nearest page
+func GetPaging(total int, pageSize int, page int) Paging {
+	paging := Paging{Page: 1, Pages: 1, PageSize: pageSize, Total: total, Last: total}
+	if pageSize <= 0 || total <= pageSize {
+		return paging
+	}
+	paging.Pages = (total + pageSize - 1) / pageSize
+	paging.Page = min(max(page, 1), paging.Pages)
+	paging.First = (paging.Page - 1) * pageSize
+	paging.Last = min(paging.First+pageSize, total)
+	return paging
+}
+
+// HasPages returns true if the books are split over more than one page
+func (p Paging) HasPages() bool {
+	return p.Pages > 1
+}
+
+// Previous returns the number of the page before this one, or zero if there isn't one
+func (p Paging) Previous() int {
+	if p.Page > 1 {
+		return p.Page - 1
+	}
+	return 0
+}
+
+// Next returns the number of the page after this one, or zero if there isn't one
+func (p Paging) Next() int {
+	if p.Page < p.Pages {
+		return p.Page + 1
+	}
+	return 0
+}
+
+// CleanPreferences returns the preferences with anything unknown removed
+func CleanPreferences(p Preferences, filters BookFilters) Preferences {
+	if !filters.Has(p.Filter) {
+		p.Filter = ""
+	}
+	levels, _ := ParseSortLevels(p.SortField, p.SortDirection)
+	p.SortField, p.SortDirection = FormatSortLevels(levels)
+	if p.Columns != nil {
+		columns := []string{}
+		for _, column := range HomeColumns {
+			if slices.Contains(p.Columns, column.Name) {
+				columns = append(columns, column.Name)
+			}
+		}
+		p.Columns = columns
+	}
+	if !slices.Contains(PageSizes, p.PageSize) {
+		p.PageSize = 0
+	}
+	return p
+}
+
+// PreferencesStore keeps each browser's preferences in a file on the server,
+// so they survive restarts and work even where cookies are unreliable
+type PreferencesStore struct {
+	filename    string
+	lock        sync.Mutex
+	preferences map[string]Preferences
+}
+
+// NewPreferencesStore loads the preferences kept in a file, if it exists
+func NewPreferencesStore(filename string) (*PreferencesStore, error) {
+	store := &PreferencesStore{
+		filename:    filename,
+		preferences: map[string]Preferences{},
+	}
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store.preferences); err != nil {
+		return nil, fmt.Errorf("error reading preferences file %s: %w", filename, err)
+	}
+	return store, nil
+}
+
+// Get returns the preferences for a browser, if there are any
+func (p *PreferencesStore) Get(browserID string) (Preferences, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	preferences, found := p.preferences[browserID]
+	return preferences, found
+}
+
+// Set changes the preferences for a browser and saves them all
+// Preferences that haven't been changed for a long time are removed, as are the
+// least recently changed beyond the most that are kept (see PreferencesMaxBrowsers)
+func (p *PreferencesStore) Set(browserID string, preferences Preferences) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	now := time.Now().UTC()
+	preferences.UpdatedUtc = now.Format(time.RFC3339)
+	p.preferences[browserID] = preferences
+	for id, existing := range p.preferences {
+		updated, err := time.Parse(time.RFC3339, existing.UpdatedUtc)
+		if err != nil || now.Sub(updated) > PreferencesMaxAge*time.Second {
+			delete(p.preferences, id)
+		}
+	}
+	if len(p.preferences) > PreferencesMaxBrowsers {
+		ids := slices.Collect(maps.Keys(p.preferences))
+		slices.SortFunc(ids, func(a, b string) int {
+			return cmp.Compare(p.preferences[b].UpdatedUtc, p.preferences[a].UpdatedUtc)
+		})
+		for _, id := range ids[PreferencesMaxBrowsers:] {
+			if id != browserID {
+				delete(p.preferences, id)
+			}
+		}
+	}
+
+	content, err := json.MarshalIndent(p.preferences, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p.filename, content, 0600)
+}
+
+// getPreferences returns the browser's preferences, from the server if they are kept there or from its cookies
+func (s *Server) getPreferences(r *http.Request) Preferences {
+	if s.Preferences != nil {
+		if browserID, err := s.CookieHandler.GetLaxCookie(r, BrowserCookie, PreferencesMaxAge); err == nil {
+			if preferences, found := s.Preferences.Get(browserID); found {
+				return CleanPreferences(preferences, s.Config.Filters)
+			}
+		}
+		return CleanPreferences(Preferences{}, s.Config.Filters)
+	}
+
+	preferences := Preferences{}
+	preferences.Filter, _ = s.CookieHandler.GetCookie(r, "mfw-filter")
+	preferences.SortField, _ = s.CookieHandler.GetCookie(r, "mfw-sort-details")
+	preferences.SortDirection, _ = s.CookieHandler.GetCookie(r, "mfw-sort-direction")
+	if columns, err := s.CookieHandler.GetCookie(r, "mfw-columns"); err == nil {
+		preferences.Columns = splitMultiValue(columns, ",")
+	}
+	if pageSize, err := s.CookieHandler.GetCookie(r, "mfw-page-size"); err == nil {
+		preferences.PageSize, _ = strconv.Atoi(pageSize)
+	}
+	return CleanPreferences(preferences, s.Config.Filters)
+}
+
+// savePreferences keeps the browser's preferences, on the server if enabled or otherwise in cookies
+func (s *Server) savePreferences(w http.ResponseWriter, r *http.Request, preferences Preferences) error {
+	preferences = CleanPreferences(preferences, s.Config.Filters)
+	if s.Preferences != nil {
+		// Only IDs this website chose and signed are used, otherwise the browser is given a new one
+		browserID, err := s.CookieHandler.GetLaxCookie(r, BrowserCookie, PreferencesMaxAge)
+		if err != nil || browserID == "" {
+			browserID = newSecurityToken()
+		}
+		if err := s.CookieHandler.SetLaxCookie(w, BrowserCookie, browserID, PreferencesMaxAge); err != nil {
+			return err
+		}
+		return s.Preferences.Set(browserID, preferences)
+	}
+
+	cookies := map[string]string{
+		"mfw-filter":         preferences.Filter,
+		"mfw-sort-details":   preferences.SortField,
+		"mfw-sort-direction": preferences.SortDirection,
+		"mfw-page-size":      strconv.Itoa(preferences.PageSize),
+	}
+	if preferences.Columns != nil {
+		cookies["mfw-columns"] = strings.Join(preferences.Columns, ",")
+	}
+	for name, value := range cookies {
+		if err := s.CookieHandler.SetCookie(w, name, value, PreferencesCookieMaxAge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
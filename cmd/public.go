@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// publicViewer is everyone browsing the read-only public catalog, who can't sign in
+var publicViewer = &User{Role: RoleViewer}
+
+// LoadBooks loads the books to show, leaving out anything private if this is the public catalog
+// Anything changing the books must use LoadFile (holding the lock), so nothing is lost when saving
+func (s *Server) LoadBooks() []Book {
+	s.BooksLock.RLock()
+	books := LoadFile(s.Filename)
+	s.BooksLock.RUnlock()
+	if s.ReadOnly {
+		books = RedactBooks(books, s.Config.Public)
+	}
+	return books
+}
+
+// RedactBooks returns the books with the private details in the settings removed
+func RedactBooks(books []Book, public PublicConfig) []Book {
+	redacted := []Book{}
+	for _, book := range books {
+		if public.OmitExceptions && book.IsException {
+			continue
+		}
+		if public.OmitNotes {
+			book.Notes = ""
+		}
+		if public.OmitRatings {
+			book.Rating = 0
+		}
+		if slices.Contains(public.RedactStatuses, book.StatusIcon) {
+			book.Status, book.StatusIcon, book.FinishedUtc = "", "", ""
+		}
+		redacted = append(redacted, book)
+	}
+	return redacted
+}
+
+// GetBorrowLink returns the link for asking to borrow a book, or an empty string if there isn't one
+func GetBorrowLink(link string, isbn string, title string) string {
+	if link == "" {
+		return ""
+	}
+
+	// Mail links use %20 for spaces, which web pages also understand
+	escape := func(value string) string {
+		return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+	}
+	return strings.NewReplacer("{isbn}", escape(isbn), "{title}", escape(title)).Replace(link)
+}
+
+// getBorrowLink returns the link for asking to borrow books, which is only offered in the public catalog
+func (s *Server) getBorrowLink() string {
+	if !s.ReadOnly {
+		return ""
+	}
+	return s.Config.Public.BorrowLink
+}
+
+// getDisplayFilename returns the books file to show in page headers
+// The public catalog only shows the name, so where it's kept stays private
+func (s *Server) getDisplayFilename() string {
+	if s.ReadOnly {
+		return filepath.Base(s.Filename)
+	}
+	return s.Filename
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PublishOptions are the choices for publishing a static site
+type PublishOptions struct {
+	OmitNotes      bool
+	OmitExceptions bool
+	Filters        BookFilters
+}
+
+// IndexEntry is a named group of books on an index page (eg one author's books)
+type IndexEntry struct {
+	Name   string
+	Anchor string
+	Books  []Book
+}
+
+// publishedSortFields are the sorts linked from the home page's column headings
+// Published pages are static, so only these single key sorts are available
+var publishedSortFields = []string{"isbn", "status", "title", "author", "series", "rating", "genre"}
+
+// Publish renders the collection as a read-only static site in a folder
+// Every filter and sort gets its own home page, every book gets a page,
+// and there are index pages for authors, series, and genres
+func Publish(dir string, filename string, books []Book, options PublishOptions) (int, error) {
+	templates, err := NewTemplates()
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove anything we've been asked not to publish
+	published := []Book{}
+	for _, book := range books {
+		if options.OmitExceptions && book.IsException {
+			continue
+		}
+		if options.OmitNotes {
+			book.Notes = ""
+		}
+		published = append(published, book)
+	}
+
+	// Start with the static files (stylesheet, icons, etc)
+	if err := os.MkdirAll(filepath.Join(dir, "books"), 0755); err != nil {
+		return 0, err
+	}
+	if err := copyStaticFiles("static", dir); err != nil {
+		return 0, err
+	}
+	var statusesCss bytes.Buffer
+	if err := WriteStatusesCss(&statusesCss, KnownStatuses); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "statuses.css"), statusesCss.Bytes(), 0644); err != nil {
+		return 0, err
+	}
+	pages := 0
+	render := func(name string, page string, data TemplateData) error {
+		var content bytes.Buffer
+		if err := templates.Render(&content, page, data); err != nil {
+			return fmt.Errorf("error rendering %s: %w", name, err)
+		}
+		pages++
+		return os.WriteFile(filepath.Join(dir, name), content.Bytes(), 0644)
+	}
+	root := &PublishSettings{Root: ""}
+
+	// Home pages for every filter, unsorted and by every column's sort in both directions
+	// The first filter is the home page (usually all the books)
+	for i, filterKey := range options.Filters.Keys() {
+		filter, _ := options.Filters.GetPopulatedFilter(filterKey, published)
+		data := TemplateData{Title: filter.Name, Filename: filepath.Base(filename), Content: filter.Books, FilterKey: filterKey, Filters: options.Filters, Publish: root}
+		if err := render(getPublishedPageName(filterKey, "", false), "home", data); err != nil {
+			return pages, err
+		}
+		if i == 0 {
+			if err := render("index.html", "home", data); err != nil {
+				return pages, err
+			}
+		}
+		for _, field := range publishedSortFields {
+			for _, descending := range []bool{false, true} {
+				sorted := make([]Book, len(filter.Books))
+				copy(sorted, filter.Books)
+				data.SortLevels = []SortLevel{{Field: field, Descending: descending}}
+				SortBooks(sorted, data.SortLevels)
+				data.Content = sorted
+				if err := render(getPublishedPageName(filterKey, field, descending), "home", data); err != nil {
+					return pages, err
+				}
+			}
+		}
+	}
+
+	// A page for every book, one folder down
+	for _, book := range published {
+		data := TemplateData{Title: book.Title, Filename: filepath.Base(filename), Content: &book, Filters: options.Filters, Publish: &PublishSettings{Root: "../"}}
+		if err := render(filepath.Join("books", getPublishedFileName(book.ISBN)+".html"), "book", data); err != nil {
+			return pages, err
+		}
+	}
+
+	// Index pages
+	for _, name := range IndexKeys {
+		data := TemplateData{Title: capitalizeWords(name), Filename: filepath.Base(filename), Content: GetIndexEntries(published, name), Filters: options.Filters, Publish: root}
+		if err := render(name+".html", "index", data); err != nil {
+			return pages, err
+		}
+	}
+
+	return pages, nil
+}
+
+// IndexKeys are the names of the indexes, in the order they are shown
+var IndexKeys = []string{"authors", "series", "genres"}
+
+// BookIndexes return the names a book is listed under in each index (eg its authors)
+var BookIndexes = map[string]func(b *Book) []string{
+	"authors": func(b *Book) []string { return b.AuthorSort },
+	"series":  func(b *Book) []string { return []string{b.Series} },
+	"genres":  func(b *Book) []string { return b.Genre },
+}
+
+// GetIndexEntries groups the books by the names they are listed under in an index
+// Series are in sequence order and other indexes use the usual fallback order
+func GetIndexEntries(books []Book, index string) []IndexEntry {
+	getNames, ok := BookIndexes[index]
+	if !ok {
+		return []IndexEntry{}
+	}
+	grouped := map[string][]Book{}
+	for _, book := range books {
+		for _, name := range getNames(&book) {
+			if name = strings.TrimSpace(name); name != "" {
+				grouped[name] = append(grouped[name], book)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(grouped))
+	for name := range grouped {
+		names = append(names, name)
+	}
+	SortStrings(names, false)
+
+	entries := make([]IndexEntry, 0, len(names))
+	for _, name := range names {
+		sortBooksByFallbackOrder(grouped[name])
+		entries = append(entries, IndexEntry{Name: name, Anchor: getAnchor(name), Books: grouped[name]})
+	}
+	return entries
+}
+
+// getPublishedPageName returns the file name of a published home page
+func getPublishedPageName(filterKey string, sortField string, descending bool) string {
+	if filterKey == "" {
+		filterKey = "all"
+	}
+	if sortField == "" {
+		return filterKey + ".html"
+	}
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+	return fmt.Sprintf("%s-%s-%s.html", filterKey, sortField, direction)
+}
+
+// getPublishedFileName returns a value (eg an ISBN) made safe for use as a file name
+func getPublishedFileName(value string) string {
+	var sb strings.Builder
+	for _, c := range value {
+		if (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c == '-' || c == '_' {
+			sb.WriteRune(c)
+		} else {
+			sb.WriteRune('_')
+		}
+	}
+	return sb.String()
+}
+
+// getAnchor returns an HTML anchor for a name, like 'may-julian'
+func getAnchor(name string) string {
+	var sb strings.Builder
+	for _, word := range strings.Fields(name) {
+		if part := citationKeyPart(word); part != "" {
+			if sb.Len() > 0 {
+				sb.WriteString("-")
+			}
+			sb.WriteString(part)
+		}
+	}
+	return sb.String()
+}
+
+// copyStaticFiles copies the files in one folder into another
+func copyStaticFiles(from string, to string) error {
+	entries, err := os.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		source, err := os.Open(filepath.Join(from, entry.Name()))
+		if err != nil {
+			return err
+		}
+		target, err := os.Create(filepath.Join(to, entry.Name()))
+		if err != nil {
+			source.Close()
+			return err
+		}
+		_, err = io.Copy(target, source)
+		source.Close()
+		if closeErr := target.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
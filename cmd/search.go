@@ -0,0 +1,412 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A search is free text and field qualifiers, all of which must match unless separated by OR
+// For example: `author:"julian may" rating>=4 -status:G` or `series:pliocene OR series:galactic`
+// Terms can be negated with a leading '-', and grouped with brackets
+
+// SearchTextFields are the qualifiers that find text within a book's details
+var SearchTextFields = []string{"title", "author", "series", "genre", "publisher", "isbn", "notes", "description", "language"}
+
+// SearchNumberFields are the qualifiers that compare a number from a book's details
+var SearchNumberFields = []string{"rating", "pages", "year"}
+
+// searchTermPattern matches a qualifier, its operator, and its value (eg `rating>=4`)
+var searchTermPattern = regexp.MustCompile(`^([A-Za-z]+)(:|>=|<=|>|<|=)(.*)$`)
+
+// BookQuery is a parsed search that can be matched against books
+type BookQuery struct {
+	root searchNode
+}
+
+// searchNode is part of a parsed search
+type searchNode interface {
+	matches(book *Book) bool
+}
+
+// ParseBookQuery parses a search into a query that can be matched against books
+func ParseBookQuery(text string) (BookQuery, error) {
+	tokens, err := tokeniseSearch(text)
+	if err != nil {
+		return BookQuery{}, err
+	}
+	if len(tokens) == 0 {
+		return BookQuery{}, nil
+	}
+	parser := searchParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return BookQuery{}, err
+	}
+	if parser.position < len(parser.tokens) {
+		return BookQuery{}, errors.New("unexpected ')' in search")
+	}
+	return BookQuery{root: root}, nil
+}
+
+// Matches returns true if the book matches the query (an empty query matches every book)
+func (q BookQuery) Matches(book *Book) bool {
+	return q.root == nil || q.root.matches(book)
+}
+
+// SearchBooks returns the books that match a search (see ParseBookQuery), in their original order
+func SearchBooks(books []Book, text string) ([]Book, error) {
+	query, err := ParseBookQuery(text)
+	if err != nil {
+		return nil, err
+	}
+	found := []Book{}
+	for i := range books {
+		if query.Matches(&books[i]) {
+			found = append(found, books[i])
+		}
+	}
+	return found, nil
+}
+
+// searchToken is a word or quoted phrase from a search
+type searchToken struct {
+	text string
+
+	// quoted is set if the token starts with a quote, so is only ever free text
+	quoted bool
+}
+
+// tokeniseSearch splits a search into words, quoted phrases, and brackets
+// Quotes can also appear part way through a word (eg `author:"julian may"`)
+func tokeniseSearch(text string) ([]searchToken, error) {
+	tokens := []searchToken{}
+	var current strings.Builder
+	inQuotes, started, quoted := false, false, false
+	finish := func() {
+		if started {
+			tokens = append(tokens, searchToken{text: current.String(), quoted: quoted})
+		}
+		current.Reset()
+		started, quoted = false, false
+	}
+	for _, r := range text {
+		switch {
+		case r == '"':
+			if !started {
+				quoted = true
+			}
+			inQuotes = !inQuotes
+			started = true
+		case inQuotes:
+			current.WriteRune(r)
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			finish()
+		case r == '(' || r == ')':
+			finish()
+			tokens = append(tokens, searchToken{text: string(r)})
+		default:
+			current.WriteRune(r)
+			started = true
+		}
+	}
+	if inQuotes {
+		return nil, errors.New("unmatched quote in search")
+	}
+	finish()
+	return tokens, nil
+}
+
+// searchParser builds the parsed search from its tokens
+// OR binds more loosely than the implicit AND between terms
+type searchParser struct {
+	tokens   []searchToken
+	position int
+}
+
+// peek returns the next unquoted token's text, or an empty string if there isn't one
+func (p *searchParser) peek() string {
+	if p.position >= len(p.tokens) || p.tokens[p.position].quoted {
+		return ""
+	}
+	return p.tokens[p.position].text
+}
+
+// parseOr parses terms separated by OR
+func (p *searchParser) parseOr() (searchNode, error) {
+	either := anyNode{}
+	for {
+		node, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		either = append(either, node)
+		if p.peek() != "OR" {
+			break
+		}
+		p.position++
+	}
+	if len(either) == 1 {
+		return either[0], nil
+	}
+	return either, nil
+}
+
+// parseAnd parses terms that must all match, up to an OR or closing bracket
+func (p *searchParser) parseAnd() (searchNode, error) {
+	all := allNode{}
+	for p.position < len(p.tokens) {
+		next := p.peek()
+		if next == "OR" || next == ")" {
+			break
+		}
+		if next == "AND" {
+			p.position++
+			continue
+		}
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, node)
+	}
+	if len(all) == 0 {
+		return nil, errors.New("search has an OR or brackets with nothing to search for")
+	}
+	if len(all) == 1 {
+		return all[0], nil
+	}
+	return all, nil
+}
+
+// parseTerm parses a single (possibly negated) term or a bracketed group
+func (p *searchParser) parseTerm() (searchNode, error) {
+	token := p.tokens[p.position]
+	p.position++
+	if token.quoted {
+		return textNode{text: FoldText(token.text)}, nil
+	}
+
+	// Bracketed groups
+	if token.text == "(" {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing ')' in search")
+		}
+		p.position++
+		return node, nil
+	}
+
+	// Negated groups (eg `-(status:G OR status:X)`) and terms (eg `-status:G`)
+	if token.text == "-" && p.peek() == "(" {
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	}
+	if negated, isNegated := strings.CutPrefix(token.text, "-"); isNegated && negated != "" {
+		node, err := parseWord(negated)
+		if err != nil {
+			return nil, err
+		}
+		return notNode{node: node}, nil
+	}
+	return parseWord(token.text)
+}
+
+// parseWord parses a qualifier (eg `series:pliocene`), or free text if it isn't one (eg `Star Trek: Voyager`)
+func parseWord(word string) (searchNode, error) {
+	parts := searchTermPattern.FindStringSubmatch(word)
+	if parts == nil {
+		return textNode{text: FoldText(word)}, nil
+	}
+	field, operator, value := strings.ToLower(parts[1]), parts[2], parts[3]
+	if field == "authors" {
+		field = "author"
+	}
+	if slices.Contains(SearchNumberFields, field) {
+		return parseNumberTerm(field, operator, value)
+	}
+	if !slices.Contains(SearchTextFields, field) && field != "status" {
+		return textNode{text: FoldText(word)}, nil
+	}
+	if operator != ":" && operator != "=" {
+		return nil, fmt.Errorf("'%s' can't be compared using '%s' (only %s can)", field, operator, strings.Join(SearchNumberFields, ", "))
+	}
+	if value == "" {
+		return nil, fmt.Errorf("nothing to search for in '%s'", word)
+	}
+	return fieldNode{field: field, text: FoldText(value)}, nil
+}
+
+// parseNumberTerm parses a comparison (eg `rating>=4`) or range (eg `year:1980..1989`)
+func parseNumberTerm(field string, operator string, value string) (searchNode, error) {
+	node := numberNode{field: field, minimum: -1 << 31, maximum: 1 << 31}
+	parse := func(text string) (int, error) {
+		number, err := strconv.Atoi(strings.TrimSpace(text))
+		if err != nil {
+			return 0, fmt.Errorf("'%s' needs a whole number, not '%s'", field, text)
+		}
+		return number, nil
+	}
+
+	// Ranges can leave out either end (eg `year:..1950`)
+	if low, high, isRange := strings.Cut(value, ".."); isRange && (operator == ":" || operator == "=") {
+		var err error
+		if low != "" {
+			if node.minimum, err = parse(low); err != nil {
+				return nil, err
+			}
+		}
+		if high != "" {
+			if node.maximum, err = parse(high); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	}
+
+	number, err := parse(value)
+	if err != nil {
+		return nil, err
+	}
+	switch operator {
+	case ">":
+		node.minimum = number + 1
+	case ">=":
+		node.minimum = number
+	case "<":
+		node.maximum = number - 1
+	case "<=":
+		node.maximum = number
+	default:
+		node.minimum, node.maximum = number, number
+	}
+	return node, nil
+}
+
+// anyNode matches if any of its parts do
+type anyNode []searchNode
+
+func (n anyNode) matches(book *Book) bool {
+	for _, node := range n {
+		if node.matches(book) {
+			return true
+		}
+	}
+	return false
+}
+
+// allNode matches if all of its parts do
+type allNode []searchNode
+
+func (n allNode) matches(book *Book) bool {
+	for _, node := range n {
+		if !node.matches(book) {
+			return false
+		}
+	}
+	return true
+}
+
+// notNode matches if its part doesn't
+type notNode struct {
+	node searchNode
+}
+
+func (n notNode) matches(book *Book) bool {
+	return !n.node.matches(book)
+}
+
+// textNode matches free text in the title, authors, series, notes, description, or ISBN
+type textNode struct {
+	text string
+}
+
+func (n textNode) matches(book *Book) bool {
+	fields := []string{book.ISBN, book.Title, book.SortTitle, book.Series, book.Notes, book.Description}
+	fields = append(fields, book.Authors...)
+	fields = append(fields, book.AuthorSort...)
+	return anyContains(fields, n.text)
+}
+
+// fieldNode matches text in one of a book's details
+type fieldNode struct {
+	field string
+	text  string
+}
+
+func (n fieldNode) matches(book *Book) bool {
+	switch n.field {
+	case "title":
+		return anyContains([]string{book.Title, book.SortTitle}, n.text)
+	case "author":
+		return anyContains(append(append([]string{}, book.Authors...), book.AuthorSort...), n.text)
+	case "series":
+		return anyContains([]string{book.Series}, n.text)
+	case "genre":
+		return anyContains(book.Genre, n.text)
+	case "publisher":
+		return anyContains([]string{book.Publisher}, n.text)
+	case "isbn":
+		return anyContains([]string{book.ISBN}, n.text)
+	case "notes":
+		return anyContains([]string{book.Notes}, n.text)
+	case "description":
+		return anyContains([]string{book.Description}, n.text)
+	case "language":
+		return anyContains([]string{book.Language}, n.text)
+	case "status":
+		// A single letter is the status icon (eg `status:R`), anything longer is in its name
+		if len(n.text) == 1 {
+			return strings.EqualFold(book.StatusIcon, n.text)
+		}
+		return anyContains([]string{book.Status}, n.text)
+	}
+	return false
+}
+
+// numberNode matches a number from a book's details within a range (inclusive)
+// Books without a page count or year never match those
+type numberNode struct {
+	field   string
+	minimum int
+	maximum int
+}
+
+func (n numberNode) matches(book *Book) bool {
+	var value int
+	switch n.field {
+	case "rating":
+		value = book.Rating
+	case "pages":
+		if book.PageCount == 0 {
+			return false
+		}
+		value = book.PageCount
+	case "year":
+		year, err := strconv.Atoi(book.PublishedDate[:min(4, len(book.PublishedDate))])
+		if err != nil {
+			return false
+		}
+		value = year
+	}
+	return value >= n.minimum && value <= n.maximum
+}
+
+// anyContains returns true if any of the values contain the (folded) text, ignoring case and accents
+func anyContains(values []string, text string) bool {
+	for _, value := range values {
+		if strings.Contains(FoldText(value), text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// searchTestBooks are the books the search tests look through
+var searchTestBooks = []Book{
+	{ID: "1", Title: "Wuthering Heights", Authors: []string{"Emily Brontë"}, AuthorSort: []string{"Brontë, Emily"}, Status: "Read", StatusIcon: "R", Rating: 5, PageCount: 416, PublishedDate: "1847-12-01"},
+	{ID: "2", Title: "The Many-Coloured Land", Authors: []string{"Julian May"}, AuthorSort: []string{"May, Julian"}, Series: "Saga of Pliocene Exile", Status: "Got", StatusIcon: "G", Rating: 4, PageCount: 400, PublishedDate: "1981"},
+	{ID: "3", Title: "Intervention", Authors: []string{"Julian May"}, AuthorSort: []string{"May, Julian"}, Series: "Galactic Milieu", Status: "Read", StatusIcon: "R", Rating: 3, PublishedDate: "1987-05"},
+	{ID: "4", Title: "Star Trek: Voyager", Authors: []string{"Various"}, Genre: []string{"Science Fiction"}, Status: "Wanted", StatusIcon: "W"},
+}
+
+func TestSearchBooks(t *testing.T) {
+	tests := []struct {
+		search string
+		want   []string
+	}{
+		{"", []string{"1", "2", "3", "4"}},
+		{"bronte", []string{"1"}},
+		{"BRONTË", []string{"1"}},
+		{"author:bronte", []string{"1"}},
+		{"author:\"julian may\"", []string{"2", "3"}},
+		{"\"many-coloured land\"", []string{"2"}},
+		{"julian may", []string{"2", "3"}},
+		{"julian AND may", []string{"2", "3"}},
+		{"series:pliocene OR series:galactic", []string{"2", "3"}},
+		{"may -series:galactic", []string{"2"}},
+		{"-(status:G OR status:W)", []string{"1", "3"}},
+		{"(bronte OR intervention) rating>=4", []string{"1"}},
+		{"status:R", []string{"1", "3"}},
+		{"status:read", []string{"1", "3"}},
+		{"rating>4", []string{"1"}},
+		{"rating<4", []string{"3", "4"}},
+		{"rating=4", []string{"2"}},
+		{"pages:400..500", []string{"1", "2"}},
+		{"pages<500", []string{"1", "2"}},
+		{"year:1980..1989", []string{"2", "3"}},
+		{"year:..1900", []string{"1"}},
+		{"year:1985..", []string{"3"}},
+		{"genre:science", []string{"4"}},
+		{"Star Trek: Voyager", []string{"4"}},
+		{"colour:blue", []string{}},
+		{"\"OR\"", []string{}},
+	}
+	for _, test := range tests {
+		found, err := SearchBooks(searchTestBooks, test.search)
+		if err != nil {
+			t.Errorf("SearchBooks(%q) = %v", test.search, err)
+			continue
+		}
+		ids := []string{}
+		for _, book := range found {
+			ids = append(ids, book.ID)
+		}
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("SearchBooks(%q) found %v, want %v", test.search, ids, test.want)
+		}
+	}
+}
+
+func TestParseBookQueryErrors(t *testing.T) {
+	tests := []string{
+		"\"julian may",
+		"(julian",
+		"julian)",
+		"julian OR",
+		"OR julian",
+		"()",
+		"rating>=four",
+		"year:19..x",
+		"title>4",
+		"author:",
+	}
+	for _, search := range tests {
+		if _, err := ParseBookQuery(search); err == nil {
+			t.Errorf("ParseBookQuery(%q) succeeded, want an error", search)
+		}
+	}
+}
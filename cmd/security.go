@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// CsrfCookie holds the token that every form must send back
+const CsrfCookie = "mfw-csrf"
+
+// CsrfField is the name of the hidden form field (or X-CSRF-Token header) holding the token
+const CsrfField = "csrf_token"
+
+// FormMaximumBytes limits the size of a form sent to the website (including uploads)
+const FormMaximumBytes = 2 << 20
+
+// csrfContextKey and cspNonceContextKey are where a request's tokens are kept in its context
+type csrfContextKey struct{}
+type cspNonceContextKey struct{}
+
+// SecurityHeadersMiddleware tells browsers to only run the page's own scripts,
+// not to show pages inside other sites, and not to leak addresses to other sites
+func (s *Server) SecurityHeadersMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := newSecurityToken()
+		headers := w.Header()
+		headers.Set("Content-Security-Policy", "default-src 'self'; "+
+			"script-src 'self' 'nonce-"+nonce+"'; "+
+			"style-src 'self'; "+
+			"img-src 'self' data:; "+
+			"connect-src 'self'; "+
+			"form-action 'self'; "+
+			"frame-ancestors 'none'; "+
+			"base-uri 'none'; "+
+			"object-src 'none'")
+		headers.Set("X-Frame-Options", "DENY")
+		headers.Set("X-Content-Type-Options", "nosniff")
+		headers.Set("Referrer-Policy", "same-origin")
+		headers.Set("Cross-Origin-Opener-Policy", "same-origin")
+		headers.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=(), payment=()")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceContextKey{}, nonce)))
+	})
+}
+
+// CsrfMiddleware stops other websites sending forms to this one
+// Every browser gets a random token in a cookie, which forms must send back in a hidden field
+// The API and ingest are used by scripts and apps rather than forms, so instead of a token
+// they refuse requests that a browser says came from another site, and (when sign in is needed)
+// requests that don't say where they came from unless they are signed in with HTTP basic authentication
+func (s *Server) CsrfMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Make sure the browser has a token for its forms
+		token, err := s.CookieHandler.GetCookie(r, CsrfCookie)
+		if err != nil || token == "" {
+			token = newSecurityToken()
+			if err := s.CookieHandler.SetCookie(w, CsrfCookie, token, 0); err != nil {
+				http.Error(w, "Error setting form token cookie: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		r = r.WithContext(context.WithValue(r.Context(), csrfContextKey{}, token))
+
+		// Only requests that change things need checking
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Ingest is authenticated by a device token rather than the browser, so can come from anywhere
+		if r.URL.Path != "/api/ingest" && !isSameOrigin(r) {
+			http.Error(w, "Requests from other sites are not allowed", http.StatusForbidden)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, FormMaximumBytes)
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			// Without an Origin or Referer it can't be told whether a browser sent the session cookie
+			// from another site, so only credentials a script sends deliberately are accepted
+			if r.URL.Path != "/api/ingest" && s.AuthEnabled() && !hasSourceHeader(r) && r.Header.Get("Authorization") == "" {
+				writeApiError(w, http.StatusForbidden, "requests without an Origin or Referer header need HTTP basic authentication")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Forms must send back the token
+		sent := r.Header.Get("X-CSRF-Token")
+		if sent == "" {
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				r.ParseMultipartForm(FormMaximumBytes)
+			}
+			sent = r.PostFormValue(CsrfField)
+		}
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			http.Error(w, "The form has expired or was sent from another site; go back, refresh the page, and try again", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfToken returns the token a request's forms must send back
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfContextKey{}).(string)
+	return token
+}
+
+// cspNonce returns the value a request's page must give its scripts so they are allowed to run
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey{}).(string)
+	return nonce
+}
+
+// isSameOrigin returns false if the browser says the request came from another site
+// Requests without Origin or Referer headers (eg from scripts) are allowed
+func isSameOrigin(r *http.Request) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Header.Get("Referer")
+	}
+	if source == "" {
+		return true
+	}
+	origin, err := url.Parse(source)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(origin.Host, r.Host)
+}
+
+// hasSourceHeader returns true if the request says where it came from (with an Origin or Referer header)
+func hasSourceHeader(r *http.Request) bool {
+	return r.Header.Get("Origin") != "" || r.Header.Get("Referer") != ""
+}
+
+// newSecurityToken returns a random value that can't be guessed
+func newSecurityToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token)
+}
@@ -7,6 +7,8 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 
@@ -19,18 +21,36 @@ type Server struct {
 	Router        *mux.Router
 	Filename      string
 	CookieHandler *CookieHandler
+	Config        *Config
+
+	// BooksLock must be held while loading, changing, and saving the books,
+	// as background lookups change the file while requests are being served
+	// Reading only needs the read lock (see LoadBooks)
+	// It is shared by every server for the same books file (eg the public catalog)
+	BooksLock *sync.RWMutex
+	Jobs      *JobQueue
+	Webhooks  *Webhooks
+
+	// Preferences is only set when they are kept on the server rather than in cookies
+	Preferences *PreferencesStore
+
+	// ReadOnly is set for the public catalog, which has no way to change
+	// the books and leaves out the private details in the settings
+	ReadOnly bool
 }
 
 // NewServer creates a new server
-func NewServer(port int, filename string, altCookies bool) (*Server, error) {
+// Servers for the same books file must be given the same books lock
+func NewServer(port int, filename string, booksLock *sync.RWMutex, altCookies bool, config *Config, webhooks *Webhooks, readOnly bool) (*Server, error) {
 	// Initialize templates
 	_, err := NewTemplates()
 	if err != nil {
 		return nil, fmt.Errorf("error initializing templates: %w", err)
 	}
 
-	// Initialize cookie handler
-	cookieHandler, err := NewCookieHandler(altCookies)
+	// Initialize cookie handler, with keys kept next to the books so cookies survive restarts
+	dataFolder := filepath.Dir(filename)
+	cookieHandler, err := NewCookieHandler(altCookies, filepath.Join(dataFolder, SecretsFilename))
 	if err != nil {
 		return nil, fmt.Errorf("error initializing cookie handler: %w", err)
 	}
@@ -39,18 +59,50 @@ func NewServer(port int, filename string, altCookies bool) (*Server, error) {
 		Port:          port,
 		Router:        mux.NewRouter(),
 		Filename:      filename,
+		BooksLock:     booksLock,
 		CookieHandler: cookieHandler,
+		Config:        config,
+		Webhooks:      webhooks,
+		ReadOnly:      readOnly,
 	}
 
-	// Add handlers
-	s.Router.HandleFunc("/", s.HomeHandler).Methods("GET")
-	s.Router.HandleFunc("/add", s.AddHandler).Methods("GET")
-	s.Router.HandleFunc("/books/search", s.SearchHandler).Methods("POST")
-	s.Router.HandleFunc("/message/{status}", s.MessageHandler).Methods("GET")
-	s.Router.HandleFunc("/sort/{field}", s.SortHandler).Methods("GET")
-	s.Router.HandleFunc("/filter/{filter}", s.FilterHandler).Methods("GET")
-	s.Router.HandleFunc("/books/edit/{isbn}", s.EditHandler).Methods("GET")
-	s.Router.HandleFunc("/books/save/{isbn}", s.SaveHandler).Methods("POST")
+	// Keep preferences on the server if asked to
+	// The public catalog always uses cookies, so visitors don't add to the file
+	if config.ServerPreferences && !readOnly {
+		s.Preferences, err = NewPreferencesStore(filepath.Join(dataFolder, PreferencesFilename))
+		if err != nil {
+			return nil, fmt.Errorf("error loading preferences: %w", err)
+		}
+	}
+
+	// Add security headers and form protection to everything
+	s.Router.Use(s.SecurityHeadersMiddleware, s.CsrfMiddleware)
+
+	// Add read-only API handlers
+	s.Router.HandleFunc("/api/v1/openapi.json", s.requireRole(RoleViewer, s.ApiOpenApiHandler)).Methods("GET")
+	s.Router.HandleFunc("/api/v1/stats", s.requireRole(RoleViewer, s.ApiStatsHandler)).Methods("GET")
+	s.Router.HandleFunc("/api/v1/books", s.requireRole(RoleViewer, s.ApiListBooksHandler)).Methods("GET")
+	s.Router.HandleFunc("/api/v1/books/{isbn}", s.requireRole(RoleViewer, s.ApiGetBookHandler)).Methods("GET")
+
+	// Add read-only handlers (the status colours are needed even before signing in)
+	s.Router.HandleFunc("/statuses.css", s.StatusesCssHandler).Methods("GET")
+	s.Router.HandleFunc("/", s.requireRole(RoleViewer, s.HomeHandler)).Methods("GET")
+	s.Router.HandleFunc("/message/{status}", s.requireRole(RoleViewer, s.MessageHandler)).Methods("GET")
+	s.Router.HandleFunc("/sort", s.requireRole(RoleViewer, s.SortHandler)).Methods("GET")
+	s.Router.HandleFunc("/sort/{field}", s.requireRole(RoleViewer, s.SortHandler)).Methods("GET")
+	s.Router.HandleFunc("/filter/{filter}", s.requireRole(RoleViewer, s.FilterHandler)).Methods("GET")
+	s.Router.HandleFunc("/preferences", s.requireRole(RoleViewer, s.PreferencesHandler)).Methods("POST")
+	s.Router.HandleFunc("/export", s.requireRole(RoleViewer, s.ExportHandler)).Methods("GET")
+	s.Router.HandleFunc("/feeds/{feed:added|finished}.atom", s.requireRole(RoleViewer, s.FeedHandler)).Methods("GET")
+	s.Router.HandleFunc("/opds/{version:v1|v2}/{feed}", s.requireRole(RoleViewer, s.OpdsHandler)).Methods("GET")
+	s.Router.HandleFunc("/books/view/{isbn}", s.requireRole(RoleViewer, s.ViewHandler)).Methods("GET")
+	s.Router.HandleFunc("/books/cite/{isbn}", s.requireRole(RoleViewer, s.CiteHandler)).Methods("GET")
+
+	// The public catalog can't change anything, so has no other handlers
+	if !readOnly {
+		s.Jobs = NewJobQueue(s)
+		s.addEditingHandlers()
+	}
 
 	// Add root-level static file handler (must come after specific routes)
 	s.Router.PathPrefix("/").Handler(http.FileServer(http.Dir("static")))
@@ -60,6 +112,36 @@ func NewServer(port int, filename string, altCookies bool) (*Server, error) {
 	return s, nil
 }
 
+// addEditingHandlers adds the handlers that change the books, and those for signing in to do so
+func (s *Server) addEditingHandlers() {
+	// Add API handlers (ingest uses its own device tokens)
+	s.Router.HandleFunc("/api/v1/books", s.requireRole(RoleEditor, s.ApiCreateBookHandler)).Methods("POST")
+	s.Router.HandleFunc("/api/v1/books/lookup", s.requireRole(RoleEditor, s.ApiLookupBookHandler)).Methods("POST")
+	s.Router.HandleFunc("/api/v1/books/{isbn}", s.requireRole(RoleEditor, s.ApiUpdateBookHandler)).Methods("PATCH")
+	s.Router.HandleFunc("/api/v1/books/{isbn}", s.requireRole(RoleEditor, s.ApiDeleteBookHandler)).Methods("DELETE")
+	s.Router.HandleFunc("/api/ingest", s.IngestHandler).Methods("POST")
+
+	// Add sign in handlers
+	s.Router.HandleFunc("/login", s.LoginHandler).Methods("GET")
+	s.Router.HandleFunc("/login", s.LoginPostHandler).Methods("POST")
+	s.Router.HandleFunc("/logout", s.LogoutHandler).Methods("POST")
+
+	// Add editing handlers
+	s.Router.HandleFunc("/add", s.requireRole(RoleEditor, s.AddHandler)).Methods("GET")
+	s.Router.HandleFunc("/books/search", s.requireRole(RoleEditor, s.SearchHandler)).Methods("POST")
+	s.Router.HandleFunc("/jobs", s.requireRole(RoleEditor, s.JobsHandler)).Methods("GET")
+	s.Router.HandleFunc("/jobs", s.requireRole(RoleEditor, s.BulkAddHandler)).Methods("POST")
+	s.Router.HandleFunc("/jobs/{id:[0-9]+}", s.requireRole(RoleEditor, s.JobHandler)).Methods("GET")
+	s.Router.HandleFunc("/jobs/{id:[0-9]+}/events", s.requireRole(RoleEditor, s.JobEventsHandler)).Methods("GET")
+	s.Router.HandleFunc("/books/edit/{isbn}", s.requireRole(RoleEditor, s.EditHandler)).Methods("GET")
+	s.Router.HandleFunc("/books/save/{isbn}", s.requireRole(RoleEditor, s.SaveHandler)).Methods("POST")
+
+	// Add admin handlers
+	s.Router.HandleFunc("/webhooks", s.requireRole(RoleAdmin, s.WebhooksHandler)).Methods("GET")
+	s.Router.HandleFunc("/authors", s.requireRole(RoleAdmin, s.AuthorsHandler)).Methods("GET")
+	s.Router.HandleFunc("/authors/merge", s.requireRole(RoleAdmin, s.AuthorsMergeHandler)).Methods("POST")
+}
+
 // Start starts the server
 func (s *Server) Start() {
 	// Create server
@@ -73,6 +155,11 @@ func (s *Server) Start() {
 
 	// Start the server
 	go func() {
+		if s.ReadOnly {
+			log.Printf("Public catalog (read-only) starting on %s", srv.Addr)
+			serverErrors <- srv.ListenAndServe()
+			return
+		}
 		fmt.Println()
 		fmt.Println()
 		fmt.Println("IMPORTANT")
@@ -2,140 +2,267 @@ package main
 
 import (
 	"cmp"
+	"fmt"
 	"slices"
-	"strconv"
 	"strings"
 )
 
-// SortBooksByISBN sorts books by ISBN, then author, then series/sequence
-func SortBooksByISBN(books []Book, descending bool) {
-	sortBooksByFallbackOrder(books)
-	slices.SortStableFunc(books, func(a, b Book) int {
-		result := strings.Compare(a.ISBN, b.ISBN)
-		if descending {
-			return -result
-		}
-		return result
-	})
+// SortKey is something books can be sorted by (eg "title")
+type SortKey struct {
+	// Name is used in links and settings, and Label is shown
+	Name  string
+	Label string
+
+	// Descending is the direction used when the key is first chosen
+	Descending bool
+
+	// Compare orders two books by this key alone
+	// Books without a value come last in either direction
+	Compare func(a, b *Book, descending bool) int
 }
 
-// SortBooksByStatus sorts books by status, then author, then series/sequence
-func SortBooksByStatus(books []Book, descending bool) {
-	sortBooksByFallbackOrder(books)
-	slices.SortStableFunc(books, func(a, b Book) int {
-		// Get status letters
-		aStatus := a.GetStatusLetter()
-		bStatus := b.GetStatusLetter()
-
-		// If either book has no status, sort it to the end
-		if aStatus == "-" && bStatus == "-" {
-			return 0
-		} else if aStatus == "-" {
-			return 1 // a has no status, sort it after b
-		} else if bStatus == "-" {
-			return -1 // b has no status, sort it after a
+// SortKeys are the keys books can be sorted by, in the order they are offered
+var SortKeys = []SortKey{
+	{Name: "isbn", Label: "ISBN", Compare: compareByText(func(b *Book) string { return b.ISBN }, false)},
+	{Name: "status", Label: "Status", Compare: compareByStatus},
+	{Name: "title", Label: "Title", Compare: compareByText((*Book).GetSortTitle, false)},
+	{Name: "author", Label: "Author", Compare: compareByText((*Book).GetFirstAuthorSort, false)},
+	{Name: "series", Label: "Series", Compare: compareBySeries},
+	{Name: "rating", Label: "Rating", Descending: true, Compare: compareByRating},
+	{Name: "genre", Label: "Genre", Compare: compareByText((*Book).GetFirstGenre, true)},
+	{Name: "published", Label: "Published", Compare: compareByText(func(b *Book) string { return b.PublishedDate }, true)},
+	{Name: "pages", Label: "Pages", Compare: compareByNumber(func(b *Book) int { return b.PageCount })},
+	{Name: "publisher", Label: "Publisher", Compare: compareByText(func(b *Book) string { return b.Publisher }, true)},
+	{Name: "language", Label: "Language", Compare: compareByText(func(b *Book) string { return b.Language }, true)},
+	{Name: "added", Label: "Added", Descending: true, Compare: compareByText((*Book).GetAddedUtc, true)},
+	{Name: "finished", Label: "Finished", Descending: true, Compare: compareByText(func(b *Book) string { return b.FinishedUtc }, true)},
+}
+
+// SortFields are the names of the keys books can be sorted by
+var SortFields = getSortKeyNames()
+
+// MaxSortLevels is how many keys a sort can have
+const MaxSortLevels = 4
+
+// GetSortKey returns the sort key with a name (ignoring case)
+// The second return value is false if there is no such key
+func GetSortKey(name string) (SortKey, bool) {
+	for _, key := range SortKeys {
+		if strings.EqualFold(key.Name, strings.TrimSpace(name)) {
+			return key, true
 		}
+	}
+	return SortKey{}, false
+}
 
-		// Priority order: C and N first, then others alphabetically
-		if aStatus == "C" || aStatus == "N" {
-			if bStatus == "C" || bStatus == "N" {
-				// Both are priority statuses, compare them
-				result := strings.Compare(aStatus, bStatus)
-				if descending {
-					return -result
-				}
-				return result
+// getSortKeyNames returns the names of the sort keys
+func getSortKeyNames() []string {
+	names := []string{}
+	for _, key := range SortKeys {
+		names = append(names, key.Name)
+	}
+	return names
+}
+
+// SortLevel is one of the keys in a sort (eg genre, then author, then series)
+type SortLevel struct {
+	Field      string
+	Descending bool
+}
+
+// Label returns the name of the level's key as shown
+func (l SortLevel) Label() string {
+	if key, ok := GetSortKey(l.Field); ok {
+		return key.Label
+	}
+	return l.Field
+}
+
+// Direction returns the direction of the level, as used in links ("asc" or "desc")
+func (l SortLevel) Direction() string {
+	if l.Descending {
+		return "desc"
+	}
+	return "asc"
+}
+
+// ParseSortLevels parses comma-separated keys and their directions (eg "genre,rating" and "asc,desc")
+// Directions are matched to keys by position, and any not given are ascending
+// Blank and repeated keys are skipped, as are unknown ones (which are also reported in the error)
+func ParseSortLevels(fields string, directions string) ([]SortLevel, error) {
+	levels := []SortLevel{}
+	unknown := []string{}
+	dirs := strings.Split(directions, ",")
+	for i, field := range strings.Split(fields, ",") {
+		key, ok := GetSortKey(field)
+		if !ok {
+			if strings.TrimSpace(field) != "" {
+				unknown = append(unknown, strings.TrimSpace(field))
 			}
-			return -1 // a is priority, sort it before b
+			continue
 		}
-		if bStatus == "C" || bStatus == "N" {
-			return 1 // b is priority, sort it before a
+		if slices.ContainsFunc(levels, func(l SortLevel) bool { return l.Field == key.Name }) || len(levels) >= MaxSortLevels {
+			continue
 		}
+		descending := i < len(dirs) && strings.EqualFold(strings.TrimSpace(dirs[i]), "desc")
+		levels = append(levels, SortLevel{Field: key.Name, Descending: descending})
+	}
+	if len(unknown) > 0 {
+		return levels, fmt.Errorf("unknown sort '%s' (expected one of %s)", strings.Join(unknown, ","), strings.Join(SortFields, ", "))
+	}
+	return levels, nil
+}
 
-		// Neither is priority, compare alphabetically
-		result := strings.Compare(aStatus, bStatus)
-		if descending {
-			return -result
-		}
-		return result
-	})
+// FormatSortLevels returns the comma-separated keys and directions of a sort (see ParseSortLevels)
+func FormatSortLevels(levels []SortLevel) (string, string) {
+	fields, directions := []string{}, []string{}
+	for _, level := range levels {
+		fields = append(fields, level.Field)
+		directions = append(directions, level.Direction())
+	}
+	return strings.Join(fields, ","), strings.Join(directions, ",")
 }
 
-// SortBooksByTitle sorts books by title, then author, then series/sequence
-func SortBooksByTitle(books []Book, descending bool) {
-	sortBooksByFallbackOrder(books)
-	slices.SortStableFunc(books, func(a, b Book) int {
-		result := strings.Compare(a.Title, b.Title)
-		if descending {
-			return -result
+// ChooseSortField returns the sort after a key is chosen (eg by clicking a column heading)
+// Choosing the first key again inverts it, otherwise the key replaces the sort in its default direction
+// When adding, the key becomes the next level instead (or is inverted if it is already one)
+func ChooseSortField(levels []SortLevel, field string, adding bool) []SortLevel {
+	key, ok := GetSortKey(field)
+	if !ok {
+		return levels
+	}
+	if len(levels) > 0 && levels[0].Field == key.Name && !adding {
+		chosen := slices.Clone(levels)
+		chosen[0].Descending = !chosen[0].Descending
+		return chosen
+	}
+	if !adding {
+		return []SortLevel{{Field: key.Name, Descending: key.Descending}}
+	}
+	chosen := slices.Clone(levels)
+	for i := range chosen {
+		if chosen[i].Field == key.Name {
+			chosen[i].Descending = !chosen[i].Descending
+			return chosen
 		}
-		return result
-	})
+	}
+	if len(chosen) >= MaxSortLevels {
+		return chosen
+	}
+	return append(chosen, SortLevel{Field: key.Name, Descending: key.Descending})
 }
 
-// SortBooksByAuthor sorts books by author, then series/sequence
-func SortBooksByAuthor(books []Book, descending bool) {
+// SortBooks sorts books by each level in turn, then by series/sequence, author, and title
+func SortBooks(books []Book, levels []SortLevel) {
 	sortBooksByFallbackOrder(books)
+	// Each key is kept with its own level's direction, so unknown keys don't shift the others
+	type sortStep struct {
+		key        SortKey
+		descending bool
+	}
+	steps := []sortStep{}
+	for _, level := range levels {
+		if key, ok := GetSortKey(level.Field); ok {
+			steps = append(steps, sortStep{key: key, descending: level.Descending})
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
 	slices.SortStableFunc(books, func(a, b Book) int {
-		result := strings.Compare(a.GetFirstAuthorSort(), b.GetFirstAuthorSort())
-		if descending {
-			return -result
+		for _, step := range steps {
+			if result := step.key.Compare(&a, &b, step.descending); result != 0 {
+				return result
+			}
 		}
-		return result
+		return 0
 	})
 }
 
-// SortBooksBySeries sorts books by series, then author, then sequence
-func SortBooksBySeries(books []Book, descending bool) {
-	sortBooksByFallbackOrderSupportingDescendingSeries(books, descending)
+// compareBlanks sorts books without a value to the end
+// The second return value is false if both books have a value, so need comparing
+func compareBlanks(aBlank bool, bBlank bool) (int, bool) {
+	switch {
+	case aBlank && bBlank:
+		return 0, true
+	case aBlank:
+		return 1, true // a has no value, sort it after b
+	case bBlank:
+		return -1, true // b has no value, sort it after a
+	}
+	return 0, false
 }
 
-// SortBooksByRating sorts books by rating, then author, then series/sequence
-func SortBooksByRating(books []Book, descending bool) {
-	sortBooksByFallbackOrder(books)
-	slices.SortStableFunc(books, func(a, b Book) int {
-		// If either book has no rating, sort it to the end
-		if a.Rating == 0 && b.Rating == 0 {
-			return 0
-		} else if a.Rating == 0 {
-			return 1 // a has no rating, sort it after b
-		} else if b.Rating == 0 {
-			return -1 // b has no rating, sort it after a
+// inDirection returns a comparison the right way round for the direction
+func inDirection(result int, descending bool) int {
+	if descending {
+		return -result
+	}
+	return result
+}
+
+// compareByText returns a comparison of a text value using the collation, optionally with blanks last
+func compareByText(value func(b *Book) string, blanksLast bool) func(a, b *Book, descending bool) int {
+	return func(a, b *Book, descending bool) int {
+		aValue, bValue := value(a), value(b)
+		if blanksLast {
+			if result, done := compareBlanks(aValue == "", bValue == ""); done {
+				return result
+			}
 		}
-		// Both have ratings, sort higher ratings first
-		result := cmp.Compare(b.Rating, a.Rating)
-		if descending {
-			return -result
+		return inDirection(CompareText(aValue, bValue), descending)
+	}
+}
+
+// compareByNumber returns a comparison of a number, with zero (unknown) last
+func compareByNumber(value func(b *Book) int) func(a, b *Book, descending bool) int {
+	return func(a, b *Book, descending bool) int {
+		aValue, bValue := value(a), value(b)
+		if result, done := compareBlanks(aValue == 0, bValue == 0); done {
+			return result
 		}
+		return inDirection(cmp.Compare(aValue, bValue), descending)
+	}
+}
+
+// compareByStatus compares statuses by priority (higher first in either direction), then code
+func compareByStatus(a, b *Book, descending bool) int {
+	aStatus, bStatus := a.GetStatusLetter(), b.GetStatusLetter()
+	if result, done := compareBlanks(aStatus == "-", bStatus == "-"); done {
 		return result
-	})
+	}
+
+	// Statuses with a higher priority (a lower number) come first in either direction
+	if result := cmp.Compare(KnownStatuses.Priority(aStatus), KnownStatuses.Priority(bStatus)); result != 0 {
+		return result
+	}
+	return inDirection(CompareText(aStatus, bStatus), descending)
 }
 
-// SortBooksByGenre sorts books by first genre, then author, then series/sequence
-func SortBooksByGenre(books []Book, descending bool) {
-	sortBooksByFallbackOrder(books)
-	slices.SortStableFunc(books, func(a, b Book) int {
-		// If either book has no genre, sort it to the end
-		if len(a.Genre) == 0 && len(b.Genre) == 0 {
-			return 0
-		} else if len(a.Genre) == 0 {
-			return 1 // a has no genre, sort it after b
-		} else if len(b.Genre) == 0 {
-			return -1 // b has no genre, sort it after a
-		}
-		// Both have genres, compare them
-		result := strings.Compare(a.GetFirstGenre(), b.GetFirstGenre())
-		if descending {
-			return -result
-		}
+// compareBySeries compares series (without a series last), then sequence
+func compareBySeries(a, b *Book, descending bool) int {
+	if result, done := compareBlanks(a.Series == "", b.Series == ""); done && result != 0 {
 		return result
-	})
+	}
+	if result := inDirection(CompareText(a.Series, b.Series), descending); result != 0 {
+		return result
+	}
+	return compareSequence(*a, *b)
+}
+
+// compareByRating compares ratings (unrated last)
+// Ascending puts the higher ratings first, as that is the usual way to read them
+func compareByRating(a, b *Book, descending bool) int {
+	if result, done := compareBlanks(a.Rating == 0, b.Rating == 0); done {
+		return result
+	}
+	return inDirection(cmp.Compare(b.Rating, a.Rating), descending)
 }
 
-// SortStrings sorts a slice of strings alphabetically
+// SortStrings sorts a slice of strings alphabetically (see Collation)
 func SortStrings(strings []string, descending bool) {
 	slices.SortFunc(strings, func(a, b string) int {
-		result := cmp.Compare(a, b)
+		result := CompareText(a, b)
 		if descending {
 			return -result
 		}
@@ -145,106 +272,39 @@ func SortStrings(strings []string, descending bool) {
 
 // sortBooksByFallbackOrder sorts books by Series, then Sequence, then Author, then Title
 func sortBooksByFallbackOrder(books []Book) {
-	sortBooksByFallbackOrderSupportingDescendingSeries(books, false)
-}
-
-// sortBooksByFallbackOrder sorts books by Series, then Sequence, then Author, then Title
-func sortBooksByFallbackOrderSupportingDescendingSeries(books []Book, descending bool) {
 	slices.SortStableFunc(books, func(a, b Book) int {
-		// If either book has no series, sort it to the end
-		if a.Series == "" && b.Series == "" {
-			// Both have no series, continue with other comparisons
-		} else if a.Series == "" {
-			return 1 // a has no series, sort it after b
-		} else if b.Series == "" {
-			return -1 // b has no series, sort it after a
-		} else {
-			// Both have series, compare them
-			seriesResult := strings.Compare(a.Series, b.Series)
-			if descending {
-				seriesResult = -seriesResult
-			}
-			if seriesResult != 0 {
-				return seriesResult
-			}
-		}
-
-		// Compare sequence
-		if sequenceResult := compareSequence(a, b); sequenceResult != 0 {
-			return sequenceResult
+		if result := compareBySeries(&a, &b, false); result != 0 {
+			return result
 		}
 
 		// Compare author
-		authorResult := strings.Compare(a.GetFirstAuthorSort(), b.GetFirstAuthorSort())
+		authorResult := CompareText(a.GetFirstAuthorSort(), b.GetFirstAuthorSort())
 		if authorResult != 0 {
 			return authorResult
 		}
 
 		// Compare title
-		return strings.Compare(a.Title, b.Title)
+		return CompareText(a.GetSortTitle(), b.GetSortTitle())
 	})
 }
 
-// compareSequence compares two books by their sequence
+// compareSequence compares two books by their place in a series (see ParseSequence)
+// Sequences without a number come after those with one, and books without a sequence come last
 func compareSequence(a, b Book) int {
-	// If either sequence is empty, sort it to the end
-	if a.Sequence == "" && b.Sequence == "" {
-		return 0
-	} else if a.Sequence == "" {
-		return 1 // a has no sequence, sort it after b
-	} else if b.Sequence == "" {
-		return -1 // b has no sequence, sort it after a
-	}
-
-	// Try to parse both sequences as numbers
-	aNum, aErr := strconv.Atoi(a.Sequence)
-	bNum, bErr := strconv.Atoi(b.Sequence)
-
-	// If both are pure numbers, compare them numerically
-	if aErr == nil && bErr == nil {
-		return cmp.Compare(aNum, bNum)
-	}
-
-	// Split into number and text parts
-	aNumStr := ""
-	aText := ""
-	for i, c := range a.Sequence {
-		if c >= '0' && c <= '9' {
-			aNumStr += string(c)
-		} else {
-			aText = a.Sequence[i:]
-			break
-		}
-	}
-
-	bNumStr := ""
-	bText := ""
-	for i, c := range b.Sequence {
-		if c >= '0' && c <= '9' {
-			bNumStr += string(c)
-		} else {
-			bText = b.Sequence[i:]
-			break
-		}
-	}
-
-	// Convert numeric parts
-	aNum, _ = strconv.Atoi(aNumStr)
-	bNum, _ = strconv.Atoi(bNumStr)
-
-	// Compare numeric parts first
-	if result := cmp.Compare(aNum, bNum); result != 0 {
+	if result, done := compareBlanks(a.Sequence == "", b.Sequence == ""); done {
 		return result
 	}
-
-	// If numeric parts are equal, pure numbers sort before alphanumeric
-	if aErr == nil {
-		return -1 // a is pure number, sort it before b
-	}
-	if bErr == nil {
-		return 1 // b is pure number, sort it before a
+	aSequence, aNumbered := ParseSequence(a.Sequence)
+	bSequence, bNumbered := ParseSequence(b.Sequence)
+	switch {
+	case aNumbered && bNumbered:
+		if result := aSequence.Compare(bSequence); result != 0 {
+			return result
+		}
+	case aNumbered:
+		return -1
+	case bNumbered:
+		return 1
 	}
-
-	// Both are alphanumeric with same number, compare text parts
-	return strings.Compare(aText, bText)
+	return CompareText(a.Sequence, b.Sequence)
 }
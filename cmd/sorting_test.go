@@ -0,0 +1,102 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseSortLevels(t *testing.T) {
+	tests := []struct {
+		fields     string
+		directions string
+		want       []SortLevel
+		wantErr    bool
+	}{
+		{"", "", []SortLevel{}, false},
+		{"title", "", []SortLevel{{"title", false}}, false},
+		{"genre,rating", "asc,desc", []SortLevel{{"genre", false}, {"rating", true}}, false},
+		{" Genre , RATING ", "ASC, Desc", []SortLevel{{"genre", false}, {"rating", true}}, false},
+		{"genre,rating", "desc", []SortLevel{{"genre", true}, {"rating", false}}, false},
+		{"genre,,rating", "asc,,desc", []SortLevel{{"genre", false}, {"rating", true}}, false},
+		{"title,title", "asc,desc", []SortLevel{{"title", false}}, false},
+		{"colour,rating", "asc,desc", []SortLevel{{"rating", true}}, true},
+		{"genre,colour,rating", "desc,asc,desc", []SortLevel{{"genre", true}, {"rating", true}}, true},
+		{"isbn,status,title,author,series", "", []SortLevel{{"isbn", false}, {"status", false}, {"title", false}, {"author", false}}, false},
+	}
+	for _, test := range tests {
+		levels, err := ParseSortLevels(test.fields, test.directions)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseSortLevels(%q, %q) error = %v, want an error: %v", test.fields, test.directions, err, test.wantErr)
+		}
+		if !slices.Equal(levels, test.want) {
+			t.Errorf("ParseSortLevels(%q, %q) = %v, want %v", test.fields, test.directions, levels, test.want)
+		}
+	}
+}
+
+func TestFormatSortLevels(t *testing.T) {
+	fields, directions := FormatSortLevels([]SortLevel{{"genre", false}, {"rating", true}})
+	if fields != "genre,rating" || directions != "asc,desc" {
+		t.Errorf("FormatSortLevels() = %q, %q, want \"genre,rating\", \"asc,desc\"", fields, directions)
+	}
+}
+
+func TestChooseSortField(t *testing.T) {
+	tests := []struct {
+		levels []SortLevel
+		field  string
+		adding bool
+		want   []SortLevel
+	}{
+		{[]SortLevel{}, "title", false, []SortLevel{{"title", false}}},
+		{[]SortLevel{}, "rating", false, []SortLevel{{"rating", true}}},
+		{[]SortLevel{{"title", false}}, "title", false, []SortLevel{{"title", true}}},
+		{[]SortLevel{{"title", false}, {"author", false}}, "author", false, []SortLevel{{"author", false}}},
+		{[]SortLevel{{"title", false}}, "colour", false, []SortLevel{{"title", false}}},
+		{[]SortLevel{{"genre", false}}, "rating", true, []SortLevel{{"genre", false}, {"rating", true}}},
+		{[]SortLevel{{"genre", false}, {"rating", true}}, "rating", true, []SortLevel{{"genre", false}, {"rating", false}}},
+		{[]SortLevel{{"isbn", false}, {"status", false}, {"title", false}, {"author", false}}, "series", true, []SortLevel{{"isbn", false}, {"status", false}, {"title", false}, {"author", false}}},
+	}
+	for _, test := range tests {
+		original := slices.Clone(test.levels)
+		if got := ChooseSortField(test.levels, test.field, test.adding); !slices.Equal(got, test.want) {
+			t.Errorf("ChooseSortField(%v, %q, %v) = %v, want %v", test.levels, test.field, test.adding, got, test.want)
+		}
+		if !slices.Equal(test.levels, original) {
+			t.Errorf("ChooseSortField(%v, %q, %v) changed the levels it was given", original, test.field, test.adding)
+		}
+	}
+}
+
+func TestSortBooks(t *testing.T) {
+	books := []Book{
+		{ID: "1", Title: "A", Genre: []string{"Fantasy"}, Rating: 3},
+		{ID: "2", Title: "B", Genre: []string{"Science Fiction"}, Rating: 5},
+		{ID: "3", Title: "C", Genre: []string{"Fantasy"}, Rating: 5},
+		{ID: "4", Title: "D", Rating: 4},
+	}
+	tests := []struct {
+		levels []SortLevel
+		want   []string
+	}{
+		{[]SortLevel{{"title", true}}, []string{"4", "3", "2", "1"}},
+		// Ascending ratings put the highest first
+		{[]SortLevel{{"genre", false}, {"rating", false}}, []string{"3", "1", "2", "4"}},
+		{[]SortLevel{{"genre", true}, {"rating", true}}, []string{"2", "1", "3", "4"}},
+		// An unknown level in the middle leaves the others with their own directions
+		{[]SortLevel{{"genre", false}, {"colour", true}, {"rating", false}}, []string{"3", "1", "2", "4"}},
+		{[]SortLevel{{"genre", false}, {"colour", false}, {"rating", true}}, []string{"1", "3", "2", "4"}},
+		{[]SortLevel{{"colour", true}}, []string{"1", "2", "3", "4"}},
+	}
+	for _, test := range tests {
+		sorted := slices.Clone(books)
+		SortBooks(sorted, test.levels)
+		ids := []string{}
+		for _, book := range sorted {
+			ids = append(ids, book.ID)
+		}
+		if !slices.Equal(ids, test.want) {
+			t.Errorf("SortBooks(%v) = %v, want %v", test.levels, ids, test.want)
+		}
+	}
+}
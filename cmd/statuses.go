@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strings"
+)
+
+// StatusConfig is a reading status a book can have (eg "R - Read")
+type StatusConfig struct {
+	// Code is the letter shown for the status, and Label is its name
+	Code  string `json:"code"`
+	Label string `json:"label"`
+
+	// Colour is the CSS colour of the status icon (eg "#d2dfc5")
+	Colour string `json:"colour"`
+
+	// Priority orders statuses when sorting (lowest first, then by code)
+	Priority int `json:"priority"`
+
+	// Done is set for statuses meaning the book is finished with (eg read or abandoned)
+	Done bool `json:"done"`
+}
+
+// BookStatuses are the statuses books can have, in the order they are offered
+// New books are given the first one
+type BookStatuses []StatusConfig
+
+// KnownStatuses are the statuses in use, which are the defaults unless the settings have others
+var KnownStatuses = DefaultStatuses()
+
+// statusCodePattern is what a status code can be, as it is used in CSS classes
+var statusCodePattern = regexp.MustCompile(`^[A-Z0-9]$`)
+
+// statusColourPattern is what a status colour can be (a hex colour or a CSS colour name)
+var statusColourPattern = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+
+// DefaultStatuses returns the statuses used when the settings don't have any
+func DefaultStatuses() BookStatuses {
+	return BookStatuses{
+		{Code: "U", Label: "Unread", Colour: "#e1e1e1", Priority: 10},
+		{Code: "C", Label: "Current", Colour: "#fcce89", Priority: 1},
+		{Code: "N", Label: "Next up", Colour: "#f9eec5", Priority: 2},
+		{Code: "R", Label: "Read", Colour: "#d2dfc5", Priority: 10, Done: true},
+		{Code: "A", Label: "Abandoned", Colour: "#f3baba", Priority: 10, Done: true},
+		{Code: "X", Label: "Unwanted", Colour: "#f3baba", Priority: 10},
+		{Code: "L", Label: "Lent out", Colour: "#a9cce6", Priority: 10},
+		{Code: "G", Label: "Gone", Colour: "#f3baba", Priority: 10},
+	}
+}
+
+// Full returns the status text stored with books (eg "R - Read")
+func (s StatusConfig) Full() string {
+	return s.Code + " - " + s.Label
+}
+
+// Get returns the status with a code (ignoring case)
+// The second return value is false if there is no such status
+func (s BookStatuses) Get(code string) (StatusConfig, bool) {
+	for _, status := range s {
+		if strings.EqualFold(status.Code, code) {
+			return status, true
+		}
+	}
+	return StatusConfig{}, false
+}
+
+// FindByLabel returns the status with a label (ignoring case)
+// The second return value is false if there is no such status
+func (s BookStatuses) FindByLabel(label string) (StatusConfig, bool) {
+	for _, status := range s {
+		if strings.EqualFold(status.Label, strings.TrimSpace(label)) {
+			return status, true
+		}
+	}
+	return StatusConfig{}, false
+}
+
+// Codes returns the status codes, in the order they are offered
+func (s BookStatuses) Codes() []string {
+	codes := []string{}
+	for _, status := range s {
+		codes = append(codes, status.Code)
+	}
+	return codes
+}
+
+// Initial returns the status given to new books
+func (s BookStatuses) Initial() StatusConfig {
+	if len(s) == 0 {
+		return StatusConfig{}
+	}
+	return s[0]
+}
+
+// IsDone returns true if the status code means the book is finished with
+func (s BookStatuses) IsDone(code string) bool {
+	status, ok := s.Get(code)
+	return ok && status.Done
+}
+
+// Priority returns the sort priority of a status code (unknown statuses come after known ones)
+func (s BookStatuses) Priority(code string) int {
+	if status, ok := s.Get(code); ok {
+		return status.Priority
+	}
+	return math.MaxInt
+}
+
+// Clean tidies the statuses' details, and returns an error if they can't be used
+func (s BookStatuses) Clean() error {
+	if len(s) == 0 {
+		return fmt.Errorf("there must be at least one status")
+	}
+	codes := map[string]bool{}
+	for i := range s {
+		status := &s[i]
+		status.Code = strings.ToUpper(strings.TrimSpace(status.Code))
+		status.Label = strings.TrimSpace(status.Label)
+		status.Colour = strings.TrimSpace(status.Colour)
+		if !statusCodePattern.MatchString(status.Code) {
+			return fmt.Errorf("status code '%s' must be a single letter or digit", status.Code)
+		}
+		if codes[status.Code] {
+			return fmt.Errorf("status code '%s' is used more than once", status.Code)
+		}
+		codes[status.Code] = true
+		if status.Label == "" {
+			return fmt.Errorf("status '%s' needs a label", status.Code)
+		}
+		if status.Colour != "" && !statusColourPattern.MatchString(status.Colour) {
+			return fmt.Errorf("status '%s' has colour '%s' (use eg '#d2dfc5' or 'orange')", status.Code, status.Colour)
+		}
+	}
+	return nil
+}
+
+// WriteStatusesCss writes the stylesheet that colours the status icons
+func WriteStatusesCss(w io.Writer, statuses BookStatuses) error {
+	for _, status := range statuses {
+		if status.Colour == "" {
+			continue
+		}
+		if _, err := fmt.Fprintf(w, ".status-icon-%s {\n  background: %s;\n}\n\n", status.Code, status.Colour); err != nil {
+			return err
+		}
+	}
+	return nil
+}
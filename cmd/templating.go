@@ -2,21 +2,291 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"html"
 	"html/template"
 	"io"
+	"net/url"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // TemplateData represents the data passed to templates
 type TemplateData struct {
-	Title     string
-	Filename  string
-	Content   interface{}
-	SortField string
-	Series    []string
-	Genres    []string
-	Message   template.HTML
+	Title       string
+	Filename    string
+	Content     interface{}
+	FilterKey   string
+	SortLevels  []SortLevel
+	Query       string
+	SearchError string
+	Series      []string
+	Genres      []string
+	Message     template.HTML
+
+	// Publish is set when rendering pages for a static site rather than the server
+	Publish *PublishSettings
+
+	// Filters are shown in the nav
+	Filters BookFilters
+
+	// User is whoever is signed in (nil if sign in isn't needed)
+	User *User
+
+	// SigningIn is set when sign in is needed but nobody is signed in yet
+	SigningIn bool
+
+	// CsrfToken must be sent back by every form, and CspNonce allows the page's scripts to run
+	CsrfToken string
+	CspNonce  string
+
+	// BorrowLink is how to ask to borrow a book, for the public catalog (see GetBorrowLink)
+	BorrowLink string
+
+	// Export choices offered on the home page
+	Exporters     []Exporter
+	ExportColumns []ExportColumn
+
+	// Preferences and Paging are for the home page, and are nil when publishing
+	Preferences *Preferences
+	Paging      *Paging
+}
+
+// PublishSettings are the details needed when rendering pages for a static site
+type PublishSettings struct {
+	// Root is the relative path from the page being rendered to the site root (eg "../")
+	Root string
+}
+
+// IsPublishing returns true if the page is being rendered for a static site
+func (d TemplateData) IsPublishing() bool {
+	return d.Publish != nil
+}
+
+// CanEdit returns true if the page can offer ways to change the books
+func (d TemplateData) CanEdit() bool {
+	return d.Publish == nil && !d.SigningIn && d.User.HasRole(RoleEditor)
+}
+
+// IsAdmin returns true if the page can offer the settings-related pages
+func (d TemplateData) IsAdmin() bool {
+	return d.Publish == nil && !d.SigningIn && d.User.HasRole(RoleAdmin)
+}
+
+// ShowColumn returns true if a home page column (eg "author") should be shown
+func (d TemplateData) ShowColumn(column string) bool {
+	return d.Preferences == nil || d.Preferences.ShowsColumn(column)
+}
+
+// ColumnCount returns how many home page columns are shown
+func (d TemplateData) ColumnCount() int {
+	count := 2 // the ISBN and title are always shown
+	for _, column := range HomeColumns {
+		if d.ShowColumn(column.Name) {
+			count++
+		}
+	}
+	return count
+}
+
+// ReasonColumnCount returns how many home page columns an errored book's reason spans
+func (d TemplateData) ReasonColumnCount() int {
+	if d.ShowColumn("links") {
+		return d.ColumnCount() - 2
+	}
+	return d.ColumnCount() - 1
+}
+
+// HomeColumns returns the home page columns that can be hidden
+func (d TemplateData) HomeColumns() []HomeColumn {
+	return HomeColumns
+}
+
+// PageSizes returns the choices for how many books to show on each home page
+func (d TemplateData) PageSizes() []int {
+	return PageSizes
+}
+
+// Statuses returns the statuses books can have, in the order they are offered
+func (d TemplateData) Statuses() BookStatuses {
+	return KnownStatuses
+}
+
+// StaticURL returns the link to a static file (eg "site.css")
+func (d TemplateData) StaticURL(name string) string {
+	if d.Publish != nil {
+		return d.Publish.Root + name
+	}
+	return "/" + name
+}
+
+// HomeURL returns the link to the home page
+func (d TemplateData) HomeURL() string {
+	if d.Publish != nil {
+		return d.Publish.Root + "index.html"
+	}
+	return "/"
+}
+
+// FilterURL returns the link that chooses a filter (eg "reading"), keeping the current sort and search
+func (d TemplateData) FilterURL(filterKey string) string {
+	if d.Publish != nil {
+		return d.Publish.Root + getPublishedPageName(filterKey, "", false)
+	}
+	return getHomeURL(filterKey, d.SortLevels, d.Query, 0)
+}
+
+// SortURL returns the link that sorts the current page by a field (eg "title")
+func (d TemplateData) SortURL(field string) string {
+	levels := ChooseSortField(d.SortLevels, field, false)
+	if d.Publish != nil {
+		return d.Publish.Root + getPublishedPageName(d.FilterKey, levels[0].Field, levels[0].Descending)
+	}
+	return getHomeURL(d.FilterKey, levels, d.Query, 0)
+}
+
+// AddSortURL returns the link that adds a field to the current sort (eg sorting by author, then series)
+// Published pages can't be sorted by more than one field, so it is the same as SortURL there
+func (d TemplateData) AddSortURL(field string) string {
+	if d.Publish != nil {
+		return d.SortURL(field)
+	}
+	return getHomeURL(d.FilterKey, ChooseSortField(d.SortLevels, field, true), d.Query, 0)
+}
+
+// AddSortAttr returns the attributes that let a column heading be shift-clicked to add its field to the sort
+func (d TemplateData) AddSortAttr(field string) template.HTMLAttr {
+	if d.Publish != nil {
+		return ""
+	}
+	return template.HTMLAttr(fmt.Sprintf(`data-add-sort="%s" title="Shift-click to sort by this as well"`, html.EscapeString(d.AddSortURL(field))))
+}
+
+// PageURL returns the link to a page of the current home page
+func (d TemplateData) PageURL(page int) string {
+	return getHomeURL(d.FilterKey, d.SortLevels, d.Query, page)
+}
+
+// ClearSearchURL returns the link to the current home page without the search
+func (d TemplateData) ClearSearchURL() string {
+	return getHomeURL(d.FilterKey, d.SortLevels, "", 0)
+}
+
+// SortField returns the first field of the current sort, or an empty string if unsorted
+func (d TemplateData) SortField() string {
+	if len(d.SortLevels) == 0 {
+		return ""
+	}
+	return d.SortLevels[0].Field
+}
+
+// SortDirection returns the direction of the first field of the current sort ("asc" or "desc")
+func (d TemplateData) SortDirection() string {
+	if len(d.SortLevels) == 0 {
+		return "asc"
+	}
+	return d.SortLevels[0].Direction()
+}
+
+// SortFieldList returns the fields of the current sort as used in links (eg "genre,author")
+func (d TemplateData) SortFieldList() string {
+	fields, _ := FormatSortLevels(d.SortLevels)
+	return fields
+}
+
+// SortDirectionList returns the directions of the current sort as used in links (eg "asc,desc")
+func (d TemplateData) SortDirectionList() string {
+	_, directions := FormatSortLevels(d.SortLevels)
+	return directions
+}
+
+// SortPosition returns where a field is in the current sort (1 for the first), or zero if it isn't
+func (d TemplateData) SortPosition(field string) int {
+	for i, level := range d.SortLevels {
+		if level.Field == field {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// SortKeys returns the keys offered by the sort builder
+func (d TemplateData) SortKeys() []SortKey {
+	return SortKeys
+}
+
+// SortBuilderLevels returns the current sort with an empty level to add another key, if there is room
+func (d TemplateData) SortBuilderLevels() []SortLevel {
+	levels := slices.Clone(d.SortLevels)
+	rows := max(2, min(MaxSortLevels, len(levels)+1))
+	for len(levels) < rows {
+		levels = append(levels, SortLevel{})
+	}
+	return levels
+}
+
+// getHomeURL returns a bookmarkable link to the home page with a filter, sort, search, and page
+// Anything not given is left out, so the browser's preferences are used for it instead
+// These only apply to the page they are in the link for; the preferences are only changed
+// by the sort builder, the preferences form, and the /filter and /sort actions (see SortHandler)
+func getHomeURL(filterKey string, levels []SortLevel, query string, page int) string {
+	return getViewURL("/", getViewValues(filterKey, levels, query, page))
+}
+
+// getViewURL returns a link with the query string values, if there are any
+func getViewURL(path string, values url.Values) string {
+	if len(values) == 0 {
+		return path
+	}
+	return path + "?" + values.Encode()
+}
+
+// getViewValues returns the query string values for a filter, sort, search, and page
+// Anything not given is left out
+func getViewValues(filterKey string, levels []SortLevel, query string, page int) url.Values {
+	values := url.Values{}
+	if filterKey != "" {
+		values.Set("filter", filterKey)
+	}
+	if len(levels) > 0 {
+		fields, directions := FormatSortLevels(levels)
+		values.Set("sort", fields)
+		values.Set("dir", directions)
+	}
+	if query != "" {
+		values.Set("q", query)
+	}
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return values
+}
+
+// BookURL returns the link to a book's page
+func (d TemplateData) BookURL(isbn string) string {
+	if d.Publish != nil {
+		return d.Publish.Root + "books/" + getPublishedFileName(isbn) + ".html"
+	}
+	if !d.CanEdit() {
+		return "/books/view/" + url.PathEscape(isbn)
+	}
+	return "/books/edit/" + url.PathEscape(isbn)
+}
+
+// BorrowURL returns the link for asking to borrow a book, or an empty string if there isn't one
+func (d TemplateData) BorrowURL(isbn string, title string) string {
+	return GetBorrowLink(d.BorrowLink, isbn, title)
+}
+
+// IndexURL returns the link to an entry (eg an author) in an index page (eg "authors"),
+// or an empty string if there are no index pages
+func (d TemplateData) IndexURL(index string, name string) string {
+	if d.Publish != nil {
+		return d.Publish.Root + index + ".html#" + getAnchor(name)
+	}
+	return ""
 }
 
 // Templates holds all our templates
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TitleArticles are the leading articles moved to the end of titles when sorting, by language
+// Books without a language (or with one not listed) use the English articles
+// Articles ending in an apostrophe are joined to the next word (eg "L'Étranger")
+var TitleArticles = map[string][]string{
+	"en": {"the", "a", "an"},
+	"fr": {"le", "la", "les", "l'", "l’", "un", "une"},
+	"de": {"der", "die", "das", "ein", "eine"},
+	"es": {"el", "la", "los", "las", "un", "una"},
+	"it": {"il", "lo", "la", "i", "gli", "le", "l'", "l’", "un", "uno", "una"},
+	"pt": {"o", "a", "os", "as", "um", "uma"},
+	"nl": {"de", "het", "een"},
+}
+
+// makeSortTitle returns a title as it is sorted, with any leading article for the language moved to the end
+// For example "The Adversary" becomes "Adversary, The" and (in French) "L'Étranger" becomes "Étranger, L'"
+func makeSortTitle(title string, language string) string {
+	title = strings.TrimSpace(title)
+	for _, article := range getTitleArticles(language) {
+		if len(title) <= len(article) || !strings.EqualFold(title[:len(article)], article) {
+			continue
+		}
+		rest := title[len(article):]
+		if !strings.HasSuffix(article, "'") && !strings.HasSuffix(article, "’") {
+			// Whole words only (eg "A" but not "Andromeda"), which need something after them
+			if !unicode.IsSpace(rune(rest[0])) {
+				continue
+			}
+			rest = strings.TrimSpace(rest)
+		}
+		if rest == "" {
+			continue
+		}
+		return rest + ", " + title[:len(article)]
+	}
+	return title
+}
+
+// getTitleArticles returns the articles for a language code (eg "fr" or "en-GB")
+func getTitleArticles(language string) []string {
+	code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(language)), "-")
+	if articles, ok := TitleArticles[code]; ok {
+		return articles
+	}
+	return TitleArticles["en"]
+}
+
+// restoreTitle reverses the way titles used to be stored, with "The " moved to the end (eg "Adversary, the")
+// Titles not stored that way are returned as they are, including any really ending in ", The"
+// as the old way always gave a lower case "the"
+func restoreTitle(title string) string {
+	if len(title) > 5 && strings.HasSuffix(title, ", the") {
+		return "The " + strings.TrimSpace(title[:len(title)-5])
+	}
+	return title
+}
+
+// countOldTitles returns how many of the books have titles stored the old way (see restoreTitle)
+func countOldTitles(books []Book) int {
+	count := 0
+	for _, book := range books {
+		if restoreTitle(book.Title) != book.Title {
+			count++
+		}
+	}
+	return count
+}
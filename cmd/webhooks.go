@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Book events that webhooks can be sent for
+const (
+	EventAdded         = "added"
+	EventStatusChanged = "status-changed"
+	EventFinished      = "finished"
+	EventRated         = "rated"
+	EventDeleted       = "deleted"
+)
+
+// KnownEvents are the events a webhook can ask for, in the order they are checked
+var KnownEvents = []string{EventAdded, EventStatusChanged, EventFinished, EventRated, EventDeleted}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "Pending"
+	DeliveryDelivered = "Delivered"
+	DeliveryFailed    = "Failed"
+)
+
+// WebhookAttempts is how many times a delivery is tried before giving up
+const WebhookAttempts = 5
+
+// WebhookFirstRetry is how long to wait before the first retry (it doubles for each one after)
+const WebhookFirstRetry = 2 * time.Second
+
+// WebhookTimeout is how long to wait for a webhook to respond
+const WebhookTimeout = 10 * time.Second
+
+// WebhookHistory is how many deliveries are kept for the deliveries page
+const WebhookHistory = 200
+
+// BookEvent is a change to a book, with the book as it was and as it is now
+// Before is nil for an added book, and After is nil for a deleted one
+type BookEvent struct {
+	ID          string `json:"id"`
+	Event       string `json:"event"`
+	OccurredUtc string `json:"occurredUtc"`
+	ISBN        string `json:"isbn"`
+	Before      *Book  `json:"before"`
+	After       *Book  `json:"after"`
+}
+
+// WebhookDelivery is the progress of sending an event to a webhook
+type WebhookDelivery struct {
+	Webhook    string
+	URL        string
+	Event      BookEvent
+	Status     string
+	Attempts   int
+	LastResult string
+	LastTryUtc string
+	NextTryUtc string
+	CreatedUtc string
+}
+
+// Webhooks sends book events to the webhooks in the settings, in the background
+type Webhooks struct {
+	webhooks   []WebhookConfig
+	client     *http.Client
+	lock       sync.Mutex
+	deliveries []*WebhookDelivery
+	sending    sync.WaitGroup
+}
+
+// NewWebhooks creates a sender for the webhooks in the settings
+func NewWebhooks(webhooks []WebhookConfig) *Webhooks {
+	return &Webhooks{
+		webhooks: webhooks,
+		client:   &http.Client{Timeout: WebhookTimeout},
+	}
+}
+
+// SaveBooks saves the books, sending webhooks for the changes since they were last saved
+// Anything saving books (other than a new file) should use this rather than SaveFile
+func SaveBooks(filename string, books []Book, webhooks *Webhooks) error {
+	before := LoadFile(filename)
+	if err := SaveFile(filename, books); err != nil {
+		return err
+	}
+	webhooks.Send(GetBookEvents(before, books))
+	return nil
+}
+
+// GetBookEvents compares the books before and after a change and returns the events
+func GetBookEvents(before []Book, after []Book) []BookEvent {
+	events := []BookEvent{}
+	now := time.Now().UTC().Format(time.RFC3339)
+	add := func(event string, old *Book, new *Book) {
+		isbn := ""
+		if new != nil {
+			isbn = new.ISBN
+		} else {
+			isbn = old.ISBN
+		}
+		events = append(events, BookEvent{
+			ID:          newEventID(),
+			Event:       event,
+			OccurredUtc: now,
+			ISBN:        isbn,
+			Before:      old,
+			After:       new,
+		})
+	}
+
+	// Added and changed books
+	previous := map[string]*Book{}
+	for i := range before {
+		previous[before[i].ISBN] = &before[i]
+	}
+	current := map[string]bool{}
+	for i := range after {
+		book := after[i]
+		current[book.ISBN] = true
+		old, found := previous[book.ISBN]
+		if !found {
+			add(EventAdded, nil, &book)
+			continue
+		}
+		if old.Status != book.Status {
+			add(EventStatusChanged, old, &book)
+		}
+		if book.IsFinished() && !old.IsFinished() {
+			add(EventFinished, old, &book)
+		}
+		if old.Rating != book.Rating {
+			add(EventRated, old, &book)
+		}
+	}
+
+	// Deleted books
+	for i := range before {
+		if !current[before[i].ISBN] {
+			add(EventDeleted, &before[i], nil)
+		}
+	}
+	return events
+}
+
+// Send queues the events for each webhook that wants them
+func (w *Webhooks) Send(events []BookEvent) {
+	for _, event := range events {
+		for _, webhook := range w.webhooks {
+			if !webhook.WantsEvent(event.Event) {
+				continue
+			}
+			delivery := &WebhookDelivery{
+				Webhook:    webhook.Name,
+				URL:        webhook.URL,
+				Event:      event,
+				Status:     DeliveryPending,
+				CreatedUtc: event.OccurredUtc,
+			}
+			w.lock.Lock()
+			w.deliveries = append(w.deliveries, delivery)
+			w.prune()
+			w.lock.Unlock()
+
+			w.sending.Add(1)
+			go w.deliver(webhook, delivery)
+		}
+	}
+}
+
+// prune removes the oldest finished deliveries beyond those kept for history
+// Pending ones are always kept, so they are still counted when waiting for them
+// The lock must already be held
+func (w *Webhooks) prune() {
+	finished := 0
+	for _, delivery := range w.deliveries {
+		if delivery.Status != DeliveryPending {
+			finished++
+		}
+	}
+	kept := []*WebhookDelivery{}
+	for _, delivery := range w.deliveries {
+		if delivery.Status != DeliveryPending && finished > WebhookHistory {
+			finished--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	w.deliveries = kept
+}
+
+// Deliveries returns a copy of the recent deliveries, newest first
+func (w *Webhooks) Deliveries() []WebhookDelivery {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	deliveries := make([]WebhookDelivery, 0, len(w.deliveries))
+	for i := len(w.deliveries) - 1; i >= 0; i-- {
+		deliveries = append(deliveries, *w.deliveries[i])
+	}
+	return deliveries
+}
+
+// Pending returns how many deliveries are still being tried
+func (w *Webhooks) Pending() int {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	pending := 0
+	for _, delivery := range w.deliveries {
+		if delivery.Status == DeliveryPending {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Wait blocks until every delivery has either been delivered or given up on
+func (w *Webhooks) Wait() {
+	w.sending.Wait()
+}
+
+// deliver sends the event, retrying with an increasing delay until it succeeds or runs out of attempts
+func (w *Webhooks) deliver(webhook WebhookConfig, delivery *WebhookDelivery) {
+	defer w.sending.Done()
+	body, err := json.Marshal(delivery.Event)
+	if err != nil {
+		w.update(delivery, func(d *WebhookDelivery) { d.Status, d.LastResult = DeliveryFailed, err.Error() })
+		return
+	}
+
+	wait := WebhookFirstRetry
+	for attempt := 1; attempt <= WebhookAttempts; attempt++ {
+		result, err := w.post(webhook, delivery.Event, body)
+		now := time.Now().UTC()
+		if err == nil {
+			w.update(delivery, func(d *WebhookDelivery) {
+				d.Status, d.Attempts, d.LastResult, d.LastTryUtc = DeliveryDelivered, attempt, result, now.Format(time.RFC3339)
+				d.NextTryUtc = ""
+			})
+			return
+		}
+		if attempt == WebhookAttempts {
+			w.update(delivery, func(d *WebhookDelivery) {
+				d.Status, d.Attempts, d.LastResult, d.LastTryUtc = DeliveryFailed, attempt, err.Error(), now.Format(time.RFC3339)
+				d.NextTryUtc = ""
+			})
+			log.Printf("Webhook %s gave up on %s for %s: %v", webhook.Name, delivery.Event.Event, delivery.Event.ISBN, err)
+			return
+		}
+		w.update(delivery, func(d *WebhookDelivery) {
+			d.Attempts, d.LastResult, d.LastTryUtc = attempt, err.Error(), now.Format(time.RFC3339)
+			d.NextTryUtc = now.Add(wait).Format(time.RFC3339)
+		})
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// post makes a single attempt at sending the event, signing it with the webhook's secret
+// Anything other than a 2xx response is an error, so it will be retried
+func (w *Webhooks) post(webhook WebhookConfig, event BookEvent, body []byte) (string, error) {
+	request, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "MFW-Books-DB")
+	request.Header.Set("X-MFW-Event", event.Event)
+	request.Header.Set("X-MFW-Delivery", event.ID)
+	if webhook.Secret != "" {
+		timestamp := fmt.Sprintf("%d", time.Now().Unix())
+		request.Header.Set("X-MFW-Timestamp", timestamp)
+		request.Header.Set("X-MFW-Signature", "sha256="+SignWebhook(webhook.Secret, timestamp, body))
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", fmt.Errorf("webhook responded with %s", response.Status)
+	}
+	return response.Status, nil
+}
+
+// update changes a delivery while holding the lock
+func (w *Webhooks) update(delivery *WebhookDelivery, change func(d *WebhookDelivery)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	change(delivery)
+}
+
+// SignWebhook returns the hex HMAC-SHA256 of the timestamp, a dot, and the body using the secret
+// Receivers can compare it with the X-MFW-Signature header to check a delivery is genuine,
+// and check the X-MFW-Timestamp header is recent so an old delivery can't be sent again
+func SignWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newEventID returns a random identifier for an event
+func newEventID() string {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}